@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// runCreateAdmin registers an admin, e.g. "ADMIN_PASSWORD=... ./server create-admin
+// -username alice", or with -promote gives an existing user the admin role. The
+// password is read from ADMIN_PASSWORD, or else from the first line of stdin, so
+// it does not show up in the process list.
+func runCreateAdmin(args []string, stdin io.Reader, admins domain.AdminUsecase) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "user to register as an admin")
+	promote := flags.Bool("promote", false, "give an existing user the admin role instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	if *promote {
+		user, err := admins.PromoteAdmin(context.Background(), *username)
+		if errors.Is(err, domain.ErrNotFound) {
+			return fmt.Errorf("user %s does not exist", *username)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s (user %d) is an admin\n", user.Username, user.ID)
+		return nil
+	}
+
+	password, ok := os.LookupEnv("ADMIN_PASSWORD")
+	if !ok {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user, err := admins.CreateAdmin(context.Background(), *username, password)
+	if errors.Is(err, domain.ErrUserExists) {
+		return fmt.Errorf("user %s already exists, rerun with -promote to make them an admin", *username)
+	}
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, field := range validationErr.Fields {
+			fmt.Fprintf(os.Stderr, "%s %s\n", field.Field, field.Message)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Registered %s as admin (user %d)\n", user.Username, user.ID)
+	return nil
+}
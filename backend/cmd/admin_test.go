@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type recordingAdmins struct {
+	domain.AdminUsecase
+	username string
+	password string
+	promoted string
+}
+
+func (a *recordingAdmins) CreateAdmin(ctx context.Context, username string, password string) (*domain.User, error) {
+	if username == "taken" {
+		return nil, domain.ErrUserExists
+	}
+	a.username, a.password = username, password
+	return &domain.User{ID: 1, Username: username, Role: domain.RoleAdmin}, nil
+}
+
+func (a *recordingAdmins) PromoteAdmin(ctx context.Context, username string) (*domain.User, error) {
+	a.promoted = username
+	return &domain.User{ID: 2, Username: username, Role: domain.RoleAdmin}, nil
+}
+
+func TestRunCreateAdminReadsPassword(t *testing.T) {
+	// Read from stdin even if the environment running the tests sets the variable
+	t.Setenv("ADMIN_PASSWORD", "")
+	os.Unsetenv("ADMIN_PASSWORD")
+
+	admins := &recordingAdmins{}
+	if err := runCreateAdmin([]string{"-username", "root"}, strings.NewReader("secret password\nrest"), admins); err != nil {
+		t.Fatalf("runCreateAdmin: %v", err)
+	}
+	if admins.username != "root" || admins.password != "secret password" {
+		t.Errorf("created %q with password %q from stdin", admins.username, admins.password)
+	}
+
+	t.Setenv("ADMIN_PASSWORD", "from the environment")
+	if err := runCreateAdmin([]string{"-username", "root"}, strings.NewReader("ignored\n"), admins); err != nil {
+		t.Fatalf("runCreateAdmin: %v", err)
+	}
+	if admins.password != "from the environment" {
+		t.Errorf("password = %q, want ADMIN_PASSWORD", admins.password)
+	}
+
+	if err := runCreateAdmin(nil, strings.NewReader(""), admins); err == nil {
+		t.Error("runCreateAdmin without -username succeeded")
+	}
+}
+
+// Existing users are only made admins when asked for explicitly
+func TestRunCreateAdminPromotesOnlyWithFlag(t *testing.T) {
+	t.Setenv("ADMIN_PASSWORD", "secret password")
+
+	admins := &recordingAdmins{}
+	err := runCreateAdmin([]string{"-username", "taken"}, strings.NewReader(""), admins)
+	if err == nil || !strings.Contains(err.Error(), "-promote") {
+		t.Errorf("runCreateAdmin for a taken username = %v, want an error pointing at -promote", err)
+	}
+	if admins.promoted != "" {
+		t.Errorf("promoted %q without -promote", admins.promoted)
+	}
+
+	if err := runCreateAdmin([]string{"-username", "taken", "-promote"}, strings.NewReader(""), admins); err != nil {
+		t.Fatalf("runCreateAdmin -promote: %v", err)
+	}
+	if admins.promoted != "taken" || admins.username != "" {
+		t.Errorf("promoted %q and registered %q, want taken promoted only", admins.promoted, admins.username)
+	}
+}
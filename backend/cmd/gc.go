@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// runGC reconciles the upload storage once and prints what was found. It only
+// reports unless asked to remove with "./server gc -delete".
+func runGC(args []string, reconciler domain.StorageReconciler) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	remove := flags.Bool("delete", false, "remove orphaned files and dangling records instead of only reporting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := reconciler.Reconcile(context.Background(), !*remove)
+	if err != nil {
+		return err
+	}
+
+	action := "Removed"
+	if report.DryRun {
+		action = "Would remove"
+	}
+	for _, key := range report.OrphanedFiles {
+		fmt.Fprintf(os.Stdout, "orphaned file      %s\n", key)
+	}
+	for _, id := range report.DanglingUploads {
+		fmt.Fprintf(os.Stdout, "dangling upload    %d\n", id)
+	}
+	for _, id := range report.DanglingVariants {
+		fmt.Fprintf(os.Stdout, "dangling variant   %d\n", id)
+	}
+	for _, hash := range report.UnreferencedBlobs {
+		fmt.Fprintf(os.Stdout, "unreferenced blob  %s\n", hash)
+	}
+	fmt.Fprintf(os.Stdout, "%s %d orphaned files (%d bytes), %d dangling uploads, %d dangling variants and %d unreferenced blob records\n",
+		action, len(report.OrphanedFiles), report.OrphanedBytes, len(report.DanglingUploads),
+		len(report.DanglingVariants), len(report.UnreferencedBlobs))
+	return nil
+}
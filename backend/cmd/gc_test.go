@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type recordingReconciler struct {
+	dryRun []bool
+}
+
+func (r *recordingReconciler) Reconcile(ctx context.Context, dryRun bool) (*domain.StorageReport, error) {
+	r.dryRun = append(r.dryRun, dryRun)
+	return &domain.StorageReport{DryRun: dryRun}, nil
+}
+
+func TestRunGCOnlyDeletesWhenAsked(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantDryRun bool
+	}{
+		{args: nil, wantDryRun: true},
+		{args: []string{"-delete"}, wantDryRun: false},
+		{args: []string{"-delete=false"}, wantDryRun: true},
+	}
+
+	for _, tt := range tests {
+		reconciler := &recordingReconciler{}
+		if err := runGC(tt.args, reconciler); err != nil {
+			t.Fatalf("runGC(%q): %v", tt.args, err)
+		}
+		if len(reconciler.dryRun) != 1 || reconciler.dryRun[0] != tt.wantDryRun {
+			t.Errorf("runGC(%q) reconciled with dryRun %v, want %v", tt.args, reconciler.dryRun, tt.wantDryRun)
+		}
+	}
+
+	if err := runGC([]string{"-dry-run"}, &recordingReconciler{}); err == nil {
+		t.Error("runGC accepted the removed -dry-run flag")
+	}
+}
@@ -17,8 +17,11 @@ import (
 	"github.com/xarcher/backend/config"
 	"github.com/xarcher/backend/internal/delivery/handler"
 	"github.com/xarcher/backend/internal/delivery/handler/middleware"
+	"github.com/xarcher/backend/internal/domain"
 	"github.com/xarcher/backend/internal/infrastructure/database"
 	"github.com/xarcher/backend/internal/infrastructure/jwt"
+	"github.com/xarcher/backend/internal/infrastructure/scanner"
+	"github.com/xarcher/backend/internal/infrastructure/storage"
 	"github.com/xarcher/backend/internal/repository"
 	"github.com/xarcher/backend/internal/usecase"
 )
@@ -51,28 +54,99 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
-	// Create temp directory for uploads if not exists
-	if err := os.MkdirAll(cfg.Upload.TempDir, 0755); err != nil {
-		log.Fatal("Failed to create upload directory:", err)
+	// Blob storage for uploads (creates the local upload directory if needed)
+	blobStore, err := storage.NewBlobStore(cfg.Upload)
+	if err != nil {
+		log.Fatal("Failed to initialize upload storage:", err)
 	}
 
-	// Services
-	jwtService := jwt.NewJWTService(cfg.JWT.SecretKey)
-
 	// Repositories
 	userRepository := repository.NewUserRepository(db)
 	uploadRepository := repository.NewUploadRepository(db)
+	blobRepository := repository.NewBlobRepository(db)
+	variantRepository := repository.NewVariantRepository(db)
+	quotaRepository := repository.NewQuotaRepository(db)
+	revokedTokenRepository := repository.NewRevokedTokenRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	loginAttemptRepository := repository.NewLoginAttemptRepository(db)
+	uploadSessionRepository := repository.NewUploadSessionRepository(db)
+	shareRepository := repository.NewShareRepository(db)
+	unitOfWork := repository.NewUnitOfWork(db)
+
+	storageReconciler := usecase.NewStorageReconciler(uploadRepository, blobRepository, variantRepository,
+		unitOfWork, blobStore, cfg.Upload.GC)
+
+	adminUsecase := usecase.NewAdminUsecase(userRepository, uploadRepository, quotaRepository, cfg.Quota,
+		cfg.Auth.PasswordPolicy, 10*time.Second)
+
+	// "gc" reconciles the upload storage once instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGC(os.Args[2:], storageReconciler); err != nil {
+			log.Fatal("Storage reconciliation failed:", err)
+		}
+		return
+	}
+
+	// "create-admin" bootstraps the first admin instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		if err := runCreateAdmin(os.Args[2:], os.Stdin, adminUsecase); err != nil {
+			log.Fatal("Creating admin failed:", err)
+		}
+		return
+	}
+
+	// Services
+	jwtService, err := jwt.NewJWTService(cfg.JWT, revokedTokenRepository)
+	if err != nil {
+		log.Fatal("Failed to initialize JWT service:", err)
+	}
+
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go purgeRevokedTokens(jobsCtx, jwtService, cfg.JWT.RevocationPurgeInterval)
+
+	variantGenerator := usecase.NewVariantGenerator(uploadRepository, variantRepository, blobStore,
+		cfg.Upload.Variants)
+	go variantGenerator.Run(jobsCtx)
+
+	// Uploads are only scanned when a clamd daemon is configured
+	var uploadScanner domain.UploadScanner
+	if cfg.Scan.Enabled {
+		uploadScanner = usecase.NewUploadScanner(uploadRepository, blobStore,
+			scanner.NewClamdScanner(cfg.Scan), variantGenerator, cfg.Scan)
+		go uploadScanner.Run(jobsCtx)
+	}
 
 	// Use cases
-	authUsecase := usecase.NewAuthUsecase(userRepository, jwtService, 10*time.Second)
-	uploadUsecase := usecase.NewUploadUsecase(uploadRepository, cfg.Upload, 10*time.Second)
+	authUsecase := usecase.NewAuthUsecase(userRepository, refreshTokenRepository, loginAttemptRepository,
+		jwtService, cfg.Auth, cfg.JWT.ExpiresIn, cfg.JWT.RefreshExpiresIn, 10*time.Second)
+	uploadUsecase := usecase.NewUploadUsecase(uploadRepository, blobRepository, variantRepository, quotaRepository,
+		unitOfWork, blobStore, variantGenerator, uploadScanner, cfg.Upload, cfg.Quota, 10*time.Second)
+	userUsecase := usecase.NewUserUsecase(userRepository, blobRepository, quotaRepository, unitOfWork, blobStore,
+		cfg.Quota, 10*time.Second)
+	uploadSessionUsecase := usecase.NewUploadSessionUsecase(uploadSessionRepository, uploadUsecase, quotaRepository,
+		cfg.Upload, cfg.Quota, 10*time.Second)
+	shareUsecase := usecase.NewShareUsecase(shareRepository, uploadRepository, userRepository, uploadUsecase, blobStore,
+		cfg.Share, 10*time.Second)
+
+	go purgeUploadSessions(jobsCtx, uploadSessionUsecase, cfg.Upload.SessionPurgeInterval)
+	if cfg.Upload.GC.Enabled {
+		go reconcileStorage(jobsCtx, storageReconciler, cfg.Upload.GC.Interval, cfg.Upload.GC.DryRun)
+	}
 
 	// Handlers
 	authHandler := handler.NewAuthHandler(authUsecase)
-	uploadHandler := handler.NewUploadHandler(uploadUsecase)
+	uploadHandler := handler.NewUploadHandler(uploadUsecase, cfg.Upload.MaxFileSize)
+	userHandler := handler.NewUserHandler(userUsecase)
+	adminHandler := handler.NewAdminHandler(adminUsecase)
+	uploadSessionHandler := handler.NewUploadSessionHandler(uploadSessionUsecase)
+	shareHandler := handler.NewShareHandler(shareUsecase)
 
 	// Middleware
 	authMiddleware := middleware.NewAuthMiddleware(authUsecase)
+	uploadRateLimiter := middleware.NewRateLimiter(cfg.Quota.UploadRateLimit, cfg.Quota.UploadRateWindow)
 
 	// Routes
 	r := mux.NewRouter()
@@ -80,16 +154,54 @@ func main() {
 	// Auth routes
 	r.HandleFunc("/register", authHandler.Register).Methods("POST")
 	r.HandleFunc("/login", authHandler.Login).Methods("POST")
+	r.HandleFunc("/refresh", authHandler.Refresh).Methods("POST")
 	r.HandleFunc("/revoke", authHandler.RevokeToken).Methods("POST")
+	r.HandleFunc("/logout-all", authMiddleware.Authenticate(authHandler.LogoutAll)).Methods("POST")
+	r.HandleFunc("/me/password", authMiddleware.Authenticate(authHandler.ChangePassword)).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
+
+	// Account routes
+	r.HandleFunc("/me", authMiddleware.Authenticate(userHandler.GetMe)).Methods("GET")
+	r.HandleFunc("/me", authMiddleware.Authenticate(userHandler.UpdateMe)).Methods("PATCH")
+	r.HandleFunc("/me", authMiddleware.Authenticate(userHandler.DeleteMe)).Methods("DELETE")
+	r.HandleFunc("/me/quota", authMiddleware.Authenticate(userHandler.GetQuota)).Methods("GET")
+
+	// Admin routes
+	adminOnly := func(next http.HandlerFunc) http.HandlerFunc {
+		return authMiddleware.Authenticate(middleware.RequireRole(domain.RoleAdmin)(next))
+	}
+	r.HandleFunc("/admin/users", adminOnly(adminHandler.ListUsers)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/disabled", adminOnly(adminHandler.SetUserDisabled)).Methods("PUT")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/role", adminOnly(adminHandler.SetUserRole)).Methods("PUT")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/quota", adminOnly(adminHandler.SetUserQuota)).Methods("PUT")
+	r.HandleFunc("/admin/uploads", adminOnly(adminHandler.ListUploads)).Methods("GET")
 
 	// Upload routes
 	r.HandleFunc("/upload-form", uploadHandler.ServeUploadForm).Methods("GET")
-	r.HandleFunc("/upload", authMiddleware.Authenticate(uploadHandler.UploadFile)).Methods("POST")
+	r.HandleFunc("/upload", authMiddleware.Authenticate(uploadRateLimiter.Limit(uploadHandler.UploadFile))).Methods("POST")
+	r.HandleFunc("/uploads", authMiddleware.Authenticate(uploadHandler.ListUploads)).Methods("GET")
+	r.HandleFunc("/uploads/{id:[0-9]+}", authMiddleware.Authenticate(uploadHandler.GetUpload)).Methods("GET")
+	r.HandleFunc("/uploads/{id:[0-9]+}", authMiddleware.Authenticate(uploadHandler.DeleteUpload)).Methods("DELETE")
+	r.HandleFunc("/uploads/{id:[0-9]+}/content", authMiddleware.Authenticate(uploadHandler.DownloadFile)).Methods("GET")
+	r.HandleFunc("/uploads/{id:[0-9]+}/variants/{name}", authMiddleware.Authenticate(uploadHandler.DownloadVariant)).Methods("GET")
+
+	// Resumable upload routes
+	r.HandleFunc("/uploads/sessions", authMiddleware.Authenticate(uploadRateLimiter.Limit(uploadSessionHandler.CreateSession))).Methods("POST")
+	r.HandleFunc("/uploads/sessions/{id:[A-Za-z0-9_-]+}", authMiddleware.Authenticate(uploadSessionHandler.HeadSession)).Methods("HEAD")
+	r.HandleFunc("/uploads/sessions/{id:[A-Za-z0-9_-]+}", authMiddleware.Authenticate(uploadSessionHandler.AppendChunk)).Methods("PATCH")
+	r.HandleFunc("/uploads/sessions/{id:[A-Za-z0-9_-]+}", authMiddleware.Authenticate(uploadSessionHandler.CancelSession)).Methods("DELETE")
+	r.HandleFunc("/uploads/sessions/{id:[A-Za-z0-9_-]+}/complete", authMiddleware.Authenticate(uploadSessionHandler.CompleteSession)).Methods("POST")
+
+	// Share link routes; /s/{token} is public, the token is the credential
+	r.HandleFunc("/uploads/{id:[0-9]+}/share", authMiddleware.Authenticate(shareHandler.CreateShare)).Methods("POST")
+	r.HandleFunc("/uploads/{id:[0-9]+}/shares", authMiddleware.Authenticate(shareHandler.ListShares)).Methods("GET")
+	r.HandleFunc("/uploads/{id:[0-9]+}/shares/{shareID:[0-9]+}", authMiddleware.Authenticate(shareHandler.RevokeShare)).Methods("DELETE")
+	r.HandleFunc("/s/{token:[A-Za-z0-9_.-]+}", shareHandler.DownloadShare).Methods("GET")
 
 	// CORS setup for development
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"}, // In production, specify exact origins
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		ExposedHeaders:   []string{"*"},
 		AllowCredentials: false,
@@ -120,6 +232,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -130,3 +243,83 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// purgeRevokedTokens periodically removes revocation records for tokens that have expired
+func purgeRevokedTokens(ctx context.Context, jwtService jwt.JWTService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := jwtService.PurgeExpiredRevocations(ctx)
+			if err != nil {
+				log.Printf("Failed to purge revoked tokens: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired revoked tokens", purged)
+			}
+		}
+	}
+}
+
+// purgeUploadSessions periodically discards resumable uploads that were abandoned
+func purgeUploadSessions(ctx context.Context, sessionUsecase domain.UploadSessionUsecase, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := sessionUsecase.PurgeExpiredSessions(ctx)
+			if err != nil {
+				log.Printf("Failed to purge upload sessions: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired or abandoned upload sessions", purged)
+			}
+		}
+	}
+}
+
+// reconcileStorage periodically removes, or with dryRun only reports, orphaned
+// upload files and records whose file is missing
+func reconcileStorage(ctx context.Context, reconciler domain.StorageReconciler, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := reconciler.Reconcile(ctx, dryRun)
+			if err != nil {
+				log.Printf("Failed to reconcile upload storage: %v", err)
+				continue
+			}
+			found := len(report.OrphanedFiles) + len(report.DanglingUploads) +
+				len(report.DanglingVariants) + len(report.UnreferencedBlobs)
+			if found == 0 {
+				continue
+			}
+			action := "Removed"
+			if dryRun {
+				action = "Found"
+			}
+			log.Printf("%s %d orphaned files (%d bytes), %d dangling uploads, %d dangling variants and %d unreferenced blob records",
+				action, len(report.OrphanedFiles), report.OrphanedBytes, len(report.DanglingUploads),
+				len(report.DanglingVariants), len(report.UnreferencedBlobs))
+			if dryRun {
+				log.Printf("Orphaned files: %v; dangling uploads: %v; dangling variants: %v",
+					report.OrphanedFiles, report.DanglingUploads, report.DanglingVariants)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"regexp"
 	"time"
 )
 
@@ -11,7 +12,11 @@ type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Database DatabaseConfig `yaml:"database"`
 	JWT      JWTConfig      `yaml:"jwt"`
+	Auth     AuthConfig     `yaml:"auth"`
 	Upload   UploadConfig   `yaml:"upload"`
+	Quota    QuotaConfig    `yaml:"quota"`
+	Scan     ScanConfig     `yaml:"scan"`
+	Share    ShareConfig    `yaml:"share"`
 }
 
 type ServerConfig struct {
@@ -29,13 +34,144 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	SecretKey string        `yaml:"secret_key"`
-	ExpiresIn time.Duration `yaml:"expires_in"`
+	SecretKey               string         `yaml:"secret_key"`
+	SigningKeyID            string         `yaml:"signing_key_id"`
+	Keys                    []JWTKeyConfig `yaml:"keys"`
+	Issuer                  string         `yaml:"issuer"`
+	Audience                string         `yaml:"audience"`
+	ExpiresIn               time.Duration  `yaml:"expires_in"`
+	RefreshExpiresIn        time.Duration  `yaml:"refresh_expires_in"`
+	RevocationPurgeInterval time.Duration  `yaml:"revocation_purge_interval"`
 }
 
+// JWTKeyConfig describes an RSA or Ed25519 key in PEM format. Keys without a
+// private key file are only used to verify tokens signed before a rotation.
+type JWTKeyConfig struct {
+	ID             string `yaml:"id"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+}
+
+// AuthConfig controls login throttling. Once a username or client IP reaches its
+// failure threshold within FailureWindow it is locked for BaseLockout, doubling
+// with every further failure up to MaxLockout.
+type AuthConfig struct {
+	MaxFailuresPerUsername int                  `yaml:"max_failures_per_username"`
+	MaxFailuresPerIP       int                  `yaml:"max_failures_per_ip"`
+	FailureWindow          time.Duration        `yaml:"failure_window"`
+	BaseLockout            time.Duration        `yaml:"base_lockout"`
+	MaxLockout             time.Duration        `yaml:"max_lockout"`
+	PasswordPolicy         PasswordPolicyConfig `yaml:"password_policy"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength     int  `yaml:"min_length"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+}
+
+// UploadConfig.Storage selects the blob store: "local" keeps files below TempDir,
+// "s3" uses the S3-compatible object store configured in S3. AllowedFormats lists
+// the image formats accepted for upload, out of png, jpeg, gif and webp.
+// Sanitize strips metadata (EXIF, XMP, ICC, text) from uploads and applies the
+// EXIF orientation. Uploads are held below SpoolDir until they are stored.
+// Resumable uploads are assembled below SessionDir, which instances must share,
+// and discarded once they have seen no progress for SessionTTL.
 type UploadConfig struct {
-	MaxFileSize int64  `yaml:"max_file_size"`
-	TempDir     string `yaml:"temp_dir"`
+	MaxFileSize          int64          `yaml:"max_file_size"`
+	TempDir              string         `yaml:"temp_dir"`
+	Storage              string         `yaml:"storage"`
+	AllowedFormats       []string       `yaml:"allowed_formats"`
+	Sanitize             bool           `yaml:"sanitize"`
+	SpoolDir             string         `yaml:"spool_dir"`
+	SessionDir           string         `yaml:"session_dir"`
+	SessionTTL           time.Duration  `yaml:"session_ttl"`
+	SessionPurgeInterval time.Duration  `yaml:"session_purge_interval"`
+	S3                   S3Config       `yaml:"s3"`
+	Variants             VariantsConfig `yaml:"variants"`
+	GC                   GCConfig       `yaml:"gc"`
+}
+
+// supportedImageFormats are the formats the upload pipeline can verify
+var supportedImageFormats = map[string]bool{
+	"png":  true,
+	"jpeg": true,
+	"gif":  true,
+	"webp": true,
+}
+
+// VariantsConfig describes the resized renditions generated for every upload,
+// rendered by Workers goroutines from a queue of at most QueueSize uploads.
+// Uploads still missing a variant, because the queue was full or generating
+// failed, are queued again every BackfillInterval.
+type VariantsConfig struct {
+	Workers          int             `yaml:"workers"`
+	QueueSize        int             `yaml:"queue_size"`
+	BackfillInterval time.Duration   `yaml:"backfill_interval"`
+	Definitions      []VariantConfig `yaml:"definitions"`
+}
+
+// GCConfig schedules the storage reconciler every Interval. Files and records
+// younger than MinAge are left alone, as they may belong to an upload in progress.
+// With DryRun the periodic run only logs what it would remove.
+type GCConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	MinAge   time.Duration `yaml:"min_age"`
+	DryRun   bool          `yaml:"dry_run"`
+}
+
+// VariantConfig scales an image down so neither side exceeds MaxDimension and
+// encodes it as Format ("jpeg", "png" or "webp"). Quality only applies to JPEG;
+// WebP variants are lossless.
+type VariantConfig struct {
+	Name         string `yaml:"name"`
+	MaxDimension int    `yaml:"max_dimension"`
+	Format       string `yaml:"format"`
+	Quality      int    `yaml:"quality"`
+}
+
+// QuotaConfig holds the default per-user storage quota, which admins can
+// override per user, and the rate limit on starting uploads
+type QuotaConfig struct {
+	MaxBytes         int64         `yaml:"max_bytes"`
+	MaxFiles         int           `yaml:"max_files"`
+	UploadRateLimit  int           `yaml:"upload_rate_limit"`
+	UploadRateWindow time.Duration `yaml:"upload_rate_window"`
+}
+
+// ScanConfig enables malware scanning of uploads with a clamd daemon at
+// Address ("host:port" for tcp, a socket path for unix). Uploads that could not
+// be scanned are retried every RetryInterval.
+type ScanConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Network       string        `yaml:"network"`
+	Address       string        `yaml:"address"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Workers       int           `yaml:"workers"`
+	QueueSize     int           `yaml:"queue_size"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+// ShareConfig controls public share links. Links are signed with SecretKey and
+// point below BaseURL. They expire after DefaultTTL unless the owner picks an
+// expiry, which may be at most MaxTTL away.
+type ShareConfig struct {
+	SecretKey  string        `yaml:"secret_key"`
+	BaseURL    string        `yaml:"base_url"`
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	MaxTTL     time.Duration `yaml:"max_ttl"`
+}
+
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	UsePathStyle    bool   `yaml:"use_path_style"`
 }
 
 func Load() (*Config, error) {
@@ -69,14 +205,142 @@ func validate(config *Config) error {
 		return fmt.Errorf("database password is required")
 	}
 
-	if config.JWT.SecretKey == "" || config.JWT.SecretKey == "your-secret-key" {
-		return fmt.Errorf("JWT secret key must be set and not use default value")
+	if len(config.JWT.Keys) == 0 {
+		if config.JWT.SecretKey == "" || config.JWT.SecretKey == "your-secret-key" {
+			return fmt.Errorf("JWT secret key must be set and not use default value")
+		}
+	} else if err := validateJWTKeys(config.JWT); err != nil {
+		return err
+	}
+
+	if config.JWT.Issuer == "" || config.JWT.Audience == "" {
+		return fmt.Errorf("JWT issuer and audience are required")
+	}
+
+	if config.JWT.ExpiresIn <= 0 {
+		return fmt.Errorf("JWT expiration must be greater than 0")
+	}
+
+	if config.JWT.RefreshExpiresIn <= 0 {
+		return fmt.Errorf("JWT refresh token lifetime must be greater than 0")
+	}
+
+	if config.JWT.RevocationPurgeInterval <= 0 {
+		return fmt.Errorf("JWT revocation purge interval must be greater than 0")
+	}
+
+	if config.Auth.MaxFailuresPerUsername <= 0 || config.Auth.MaxFailuresPerIP <= 0 {
+		return fmt.Errorf("auth failure thresholds must be greater than 0")
+	}
+
+	if config.Auth.FailureWindow <= 0 || config.Auth.BaseLockout <= 0 || config.Auth.MaxLockout < config.Auth.BaseLockout {
+		return fmt.Errorf("auth failure window and lockout durations must be positive, with max_lockout >= base_lockout")
+	}
+
+	if config.Auth.PasswordPolicy.MinLength <= 0 || config.Auth.PasswordPolicy.MinLength > 72 {
+		return fmt.Errorf("password minimum length must be between 1 and 72")
 	}
 
 	if config.Upload.MaxFileSize <= 0 {
 		return fmt.Errorf("max file size must be greater than 0")
 	}
 
+	if len(config.Upload.AllowedFormats) == 0 {
+		return fmt.Errorf("at least one allowed upload format is required")
+	}
+	for _, format := range config.Upload.AllowedFormats {
+		if !supportedImageFormats[format] {
+			return fmt.Errorf("unsupported upload format %q, expected png, jpeg, gif or webp", format)
+		}
+	}
+
+	if config.Upload.SpoolDir == "" {
+		return fmt.Errorf("upload spool directory is required")
+	}
+	if config.Upload.SessionDir == "" {
+		return fmt.Errorf("upload session directory is required")
+	}
+	if config.Upload.SessionTTL <= 0 || config.Upload.SessionPurgeInterval <= 0 {
+		return fmt.Errorf("upload session ttl and purge interval must be greater than 0")
+	}
+
+	if config.Quota.MaxBytes <= 0 || config.Quota.MaxFiles <= 0 {
+		return fmt.Errorf("default quota bytes and files must be greater than 0")
+	}
+	if config.Quota.UploadRateLimit <= 0 || config.Quota.UploadRateWindow <= 0 {
+		return fmt.Errorf("upload rate limit and window must be greater than 0")
+	}
+
+	if len(config.Share.SecretKey) < 16 {
+		return fmt.Errorf("share secret key must be at least 16 characters")
+	}
+	if config.Share.DefaultTTL <= 0 || config.Share.MaxTTL < config.Share.DefaultTTL {
+		return fmt.Errorf("share default ttl must be greater than 0 and at most max ttl")
+	}
+
+	if config.Scan.Enabled {
+		if config.Scan.Network != "tcp" && config.Scan.Network != "unix" {
+			return fmt.Errorf("scan network must be tcp or unix")
+		}
+		if config.Scan.Address == "" {
+			return fmt.Errorf("scan address is required when scanning is enabled")
+		}
+		if config.Scan.Timeout <= 0 || config.Scan.RetryInterval <= 0 ||
+			config.Scan.Workers <= 0 || config.Scan.QueueSize <= 0 {
+			return fmt.Errorf("scan timeout, retry interval, workers and queue size must be greater than 0")
+		}
+	}
+
+	if config.Upload.GC.MinAge <= 0 {
+		return fmt.Errorf("upload gc min age must be greater than 0")
+	}
+	if config.Upload.GC.Enabled && config.Upload.GC.Interval <= 0 {
+		return fmt.Errorf("upload gc interval must be greater than 0")
+	}
+
+	if err := validateVariants(config.Upload.Variants); err != nil {
+		return err
+	}
+
+	switch config.Upload.Storage {
+	case "", "local":
+		if config.Upload.TempDir == "" {
+			return fmt.Errorf("upload directory is required for local storage")
+		}
+	case "s3":
+		if config.Upload.S3.Endpoint == "" || config.Upload.S3.Bucket == "" {
+			return fmt.Errorf("S3 endpoint and bucket are required for s3 storage")
+		}
+	default:
+		return fmt.Errorf("unknown upload storage %q, expected local or s3", config.Upload.Storage)
+	}
+
+	return nil
+}
+
+func validateJWTKeys(cfg JWTConfig) error {
+	seen := make(map[string]bool)
+	for _, key := range cfg.Keys {
+		if key.ID == "" {
+			return fmt.Errorf("JWT key id is required")
+		}
+		if seen[key.ID] {
+			return fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+		seen[key.ID] = true
+
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			return fmt.Errorf("JWT key %q must have a private or public key file", key.ID)
+		}
+		if key.ID == cfg.SigningKeyID && key.PrivateKeyFile == "" {
+			return fmt.Errorf("JWT signing key %q must have a private key file", key.ID)
+		}
+	}
+
+	if !seen[cfg.SigningKeyID] {
+		return fmt.Errorf("JWT signing key id must reference one of the configured keys")
+	}
+
 	return nil
 }
 
@@ -94,3 +358,39 @@ func (c *Config) GetDatabaseDSN() string {
 func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func validateVariants(variants VariantsConfig) error {
+	if len(variants.Definitions) == 0 {
+		return nil
+	}
+	if variants.Workers <= 0 || variants.QueueSize <= 0 || variants.BackfillInterval <= 0 {
+		return fmt.Errorf("variant workers, queue size and backfill interval must be greater than 0")
+	}
+
+	names := map[string]bool{}
+	for _, variant := range variants.Definitions {
+		if !variantNamePattern.MatchString(variant.Name) {
+			return fmt.Errorf("invalid variant name %q", variant.Name)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("duplicate variant name %q", variant.Name)
+		}
+		names[variant.Name] = true
+
+		if variant.MaxDimension <= 0 {
+			return fmt.Errorf("variant %q max dimension must be greater than 0", variant.Name)
+		}
+		switch variant.Format {
+		case "jpeg":
+			if variant.Quality < 0 || variant.Quality > 100 {
+				return fmt.Errorf("variant %q quality must be between 0 and 100", variant.Name)
+			}
+		case "png", "webp":
+		default:
+			return fmt.Errorf("variant %q format must be jpeg, png or webp", variant.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+type AdminHandler struct {
+	adminUsecase domain.AdminUsecase
+}
+
+func NewAdminHandler(adminUsecase domain.AdminUsecase) *AdminHandler {
+	return &AdminHandler{
+		adminUsecase: adminUsecase,
+	}
+}
+
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	users, err := h.adminUsecase.ListUsers(r.Context(), limit, offset)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, users)
+}
+
+func (h *AdminHandler) SetUserDisabled(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req domain.SetDisabledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if callerID, _ := r.Context().Value("user_id").(int); callerID == userID && req.Disabled {
+		utils.RespondError(w, http.StatusBadRequest, "Cannot disable your own account")
+		return
+	}
+
+	user, err := h.adminUsecase.SetUserDisabled(r.Context(), userID, req.Disabled)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, user)
+}
+
+func (h *AdminHandler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req domain.SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.adminUsecase.SetUserRole(r.Context(), userID, req.Role)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, user)
+}
+
+func (h *AdminHandler) SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req domain.SetQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	quota, err := h.adminUsecase.SetUserQuota(r.Context(), userID, &req)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, quota)
+}
+
+func (h *AdminHandler) ListUploads(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	uploads, err := h.adminUsecase.ListUploads(r.Context(), limit, offset)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, uploads)
+}
+
+// parsePagination reads the limit and offset query parameters, falling back to
+// defaults for missing or invalid values
+func parsePagination(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 
 	"github.com/xarcher/backend/internal/domain"
@@ -25,9 +26,9 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authUsecase.Register(&req)
+	response, err := h.authUsecase.Register(r.Context(), &req)
 	if err != nil {
-		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		respondError(w, err)
 		return
 	}
 
@@ -41,9 +42,25 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authUsecase.Login(&req)
+	response, err := h.authUsecase.Login(r.Context(), &req, clientIP(r))
 	if err != nil {
-		utils.RespondError(w, http.StatusUnauthorized, err.Error())
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, response)
+}
+
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req domain.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	response, err := h.authUsecase.Refresh(r.Context(), &req)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
@@ -62,10 +79,61 @@ func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
 		token = token[7:]
 	}
 
-	if err := h.authUsecase.RevokeToken(token); err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.authUsecase.RevokeToken(r.Context(), token); err != nil {
+		respondError(w, err)
 		return
 	}
 
 	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Token revoked successfully"})
 }
+
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondJSON(w, http.StatusOK, h.authUsecase.JWKS())
+}
+
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req domain.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	response, err := h.authUsecase.ChangePassword(r.Context(), userID, &req)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, response)
+}
+
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := h.authUsecase.LogoutAll(r.Context(), userID); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Logged out from all sessions"})
+}
+
+// clientIP returns the host part of the connection's remote address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
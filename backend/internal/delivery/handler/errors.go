@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+// scanRetryAfterSeconds is suggested to clients downloading an upload that is still being scanned
+const scanRetryAfterSeconds = 5
+
+// errMalformedBody marks request bodies that could not be parsed
+var errMalformedBody = errors.New("malformed request body")
+
+// respondError maps typed domain errors to HTTP status codes. Anything it does not
+// recognise is logged and reported as a 500 without leaking the underlying message.
+func respondError(w http.ResponseWriter, err error) {
+	var validationErr *domain.ValidationError
+	var lockedErr *domain.LoginLockedError
+	var maxBytesErr *http.MaxBytesError
+	var quotaErr *domain.QuotaExceededError
+
+	switch {
+	case errors.As(err, &quotaErr):
+		setQuotaHeaders(w, quotaErr.Quota)
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, quotaErr.Error())
+	case errors.Is(err, domain.ErrFileTooLarge), errors.As(err, &maxBytesErr):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, domain.ErrFileTooLarge.Error())
+	case errors.Is(err, errMalformedBody):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &validationErr):
+		utils.RespondJSON(w, http.StatusBadRequest, validationErr)
+	case errors.As(err, &lockedErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.RetryAfter.Seconds()))))
+		utils.RespondError(w, http.StatusTooManyRequests, lockedErr.Error())
+	case errors.Is(err, domain.ErrNotFound),
+		errors.Is(err, domain.ErrBlobNotFound):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, domain.ErrScanPending):
+		w.Header().Set("Retry-After", strconv.Itoa(scanRetryAfterSeconds))
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrAccountDisabled),
+		errors.Is(err, domain.ErrForbidden),
+		errors.Is(err, domain.ErrUploadInfected):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, domain.ErrShareUnavailable):
+		utils.RespondError(w, http.StatusGone, err.Error())
+	case errors.Is(err, domain.ErrUserExists),
+		errors.Is(err, domain.ErrOffsetMismatch):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrInvalidCredentials),
+		errors.Is(err, domain.ErrInvalidToken),
+		errors.Is(err, domain.ErrTokenReused):
+		utils.RespondError(w, http.StatusUnauthorized, err.Error())
+	default:
+		log.Printf("Internal error: %v", err)
+		utils.RespondError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// setQuotaHeaders reports a user's limits and what is left of them
+func setQuotaHeaders(w http.ResponseWriter, quota *domain.UserQuota) {
+	w.Header().Set("X-Quota-Bytes-Limit", strconv.FormatInt(quota.MaxBytes, 10))
+	w.Header().Set("X-Quota-Bytes-Remaining", strconv.FormatInt(quota.RemainingBytes, 10))
+	w.Header().Set("X-Quota-Files-Limit", strconv.Itoa(quota.MaxFiles))
+	w.Header().Set("X-Quota-Files-Remaining", strconv.Itoa(quota.RemainingFiles))
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
@@ -35,16 +37,44 @@ func (m *AuthMiddleware) Authenticate(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		token := tokenParts[1]
-		claims, err := m.authUsecase.ValidateToken(token)
-		if err != nil {
+		claims, err := m.authUsecase.ValidateToken(r.Context(), token)
+		if errors.Is(err, domain.ErrInvalidToken) {
 			utils.RespondError(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
+		if errors.Is(err, domain.ErrAccountDisabled) {
+			utils.RespondError(w, http.StatusForbidden, "Account is disabled")
+			return
+		}
+		if err != nil {
+			log.Printf("Token validation failed: %v", err)
+			utils.RespondError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
 
 		// Add user info to context
 		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
 		ctx = context.WithValue(ctx, "username", claims.Username)
+		ctx = context.WithValue(ctx, "role", claims.Role)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// RequireRole only lets requests through whose authenticated user has one of the
+// given roles. It must be wrapped by Authenticate.
+func RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value("role").(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+// RateLimiter allows each authenticated user a fixed number of requests per
+// window. Counters live in memory, so every server instance limits on its own.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	windows   map[int]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: map[int]*rateWindow{},
+	}
+}
+
+// Limit must run after Authenticate, as it counts requests by user ID. Every
+// response carries the X-RateLimit-* headers; rejected requests get 429 with Retry-After.
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("user_id").(int)
+		if !ok {
+			utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		allowed, remaining, reset := l.allow(userID, time.Now())
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", resetSeconds)
+
+		if !allowed {
+			w.Header().Set("Retry-After", resetSeconds)
+			utils.RespondError(w, http.StatusTooManyRequests, "Too many requests, retry later")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// allow counts a request and returns whether it is within the limit, how many
+// requests remain in the window and how long until the window resets
+func (l *RateLimiter) allow(userID int, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget users whose windows have ended, at most once per window
+	if now.Sub(l.lastSweep) >= l.window {
+		for id, win := range l.windows {
+			if now.Sub(win.start) >= l.window {
+				delete(l.windows, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	win, ok := l.windows[userID]
+	if !ok || now.Sub(win.start) >= l.window {
+		win = &rateWindow{start: now}
+		l.windows[userID] = win
+	}
+
+	reset := win.start.Add(l.window).Sub(now)
+	if win.count >= l.limit {
+		return false, 0, reset
+	}
+	win.count++
+	return true, l.limit - win.count, reset
+}
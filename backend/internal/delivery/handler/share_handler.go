@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+// ShareHandler manages share links of uploads and serves them publicly
+type ShareHandler struct {
+	shareUsecase domain.ShareUsecase
+}
+
+func NewShareHandler(shareUsecase domain.ShareUsecase) *ShareHandler {
+	return &ShareHandler{
+		shareUsecase: shareUsecase,
+	}
+}
+
+func (h *ShareHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	uploadID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+
+	// An empty body creates a link with the default expiry
+	var req domain.CreateShareRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	share, err := h.shareUsecase.CreateShare(r.Context(), userID, role, uploadID, &req)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusCreated, share)
+}
+
+func (h *ShareHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	uploadID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+
+	shares, err := h.shareUsecase.ListShares(r.Context(), userID, role, uploadID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, shares)
+}
+
+func (h *ShareHandler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	vars := mux.Vars(r)
+	uploadID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+	shareID, err := strconv.Atoi(vars["shareID"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid share id")
+		return
+	}
+
+	if err := h.shareUsecase.RevokeShare(r.Context(), userID, role, uploadID, shareID); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DownloadShare serves a shared upload to anyone holding the link. On links
+// with a download limit every request uses a download up, counted by OpenShare;
+// on unlimited links responses with content count, 304s do not. Responses must
+// not be cached, and the page must not pass the link on to other sites in the
+// Referer header.
+func (h *ShareHandler) DownloadShare(w http.ResponseWriter, r *http.Request) {
+	share, upload, content, err := h.shareUsecase.OpenShare(r.Context(), mux.Vars(r)["token"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer content.Close()
+
+	if share.MaxDownloads == nil {
+		w = &downloadCounter{ResponseWriter: w, record: func() error {
+			return h.shareUsecase.RecordDownload(r.Context(), share.ID)
+		}}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Type", upload.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": upload.Filename}))
+
+	http.ServeContent(w, r, upload.Filename, upload.CreatedAt, content)
+}
+
+// downloadCounter records a download when the response turns out to carry
+// content, whole or ranged, before anything is sent. If recording fails, that
+// error is sent instead and the content is dropped.
+type downloadCounter struct {
+	http.ResponseWriter
+	record      func() error
+	wroteHeader bool
+	err         error
+}
+
+func (c *downloadCounter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		if c.err = c.record(); c.err != nil {
+			for _, name := range []string{"Content-Disposition", "Content-Length", "Content-Range", "Content-Type",
+				"Last-Modified", "Accept-Ranges"} {
+				c.Header().Del(name)
+			}
+			respondError(c.ResponseWriter, c.err)
+			return
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *downloadCounter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.ResponseWriter.Write(p)
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// countingShareUsecase serves one share and counts the downloads recorded for it
+type countingShareUsecase struct {
+	domain.ShareUsecase
+	content   string
+	createdAt time.Time
+	recordErr error
+	recorded  int
+	limited   bool
+}
+
+func (u *countingShareUsecase) OpenShare(ctx context.Context, token string) (*domain.UploadShare, *domain.FileUpload,
+	io.ReadSeekCloser, error) {
+	share := &domain.UploadShare{ID: 1, UploadID: 2}
+	if u.limited {
+		// OpenShare has already counted the download
+		maxDownloads := 1
+		share.MaxDownloads = &maxDownloads
+	}
+	upload := &domain.FileUpload{ID: 2, Filename: "photo.png", ContentType: "image/png", CreatedAt: u.createdAt}
+	return share, upload, nopSeekCloser{strings.NewReader(u.content)}, nil
+}
+
+func (u *countingShareUsecase) RecordDownload(ctx context.Context, shareID int) error {
+	if u.recordErr != nil {
+		return u.recordErr
+	}
+	u.recorded++
+	return nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func TestDownloadShareCountsDownloads(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		recordErr  error
+		limited    bool
+		wantStatus int
+		wantBody   string
+		wantCount  int
+	}{
+		{name: "whole file", wantStatus: http.StatusOK, wantBody: "0123456789", wantCount: 1},
+		{name: "range from the start", header: map[string]string{"Range": "bytes=0-3"},
+			wantStatus: http.StatusPartialContent, wantBody: "0123", wantCount: 1},
+		{name: "resumed range", header: map[string]string{"Range": "bytes=4-"},
+			wantStatus: http.StatusPartialContent, wantBody: "456789", wantCount: 1},
+		{name: "limited link", header: map[string]string{"Range": "bytes=4-"}, limited: true,
+			wantStatus: http.StatusPartialContent, wantBody: "456789"},
+		{name: "not modified", header: map[string]string{"If-Modified-Since": createdAt.Format(http.TimeFormat)},
+			wantStatus: http.StatusNotModified},
+		{name: "used up in the meantime", recordErr: domain.ErrShareUnavailable, wantStatus: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := &countingShareUsecase{content: "0123456789", createdAt: createdAt, recordErr: tt.recordErr,
+				limited: tt.limited}
+			h := NewShareHandler(shares)
+
+			req := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			req = mux.SetURLVars(req, map[string]string{"token": "token"})
+			rec := httptest.NewRecorder()
+
+			h.DownloadShare(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.recordErr != nil && strings.Contains(rec.Body.String(), "0123") {
+				t.Errorf("content was sent although the download was not recorded: %q", rec.Body.String())
+			}
+			if shares.recorded != tt.wantCount {
+				t.Errorf("recorded %d downloads, want %d", shares.recorded, tt.wantCount)
+			}
+		})
+	}
+}
@@ -1,24 +1,37 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/xarcher/backend/internal/domain"
 	"github.com/xarcher/backend/pkg/utils"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 )
 
+// multipartOverhead is the allowance for boundaries and part headers on top of
+// the file itself when limiting the request body
+const multipartOverhead = 64 << 10
+
 type UploadHandler struct {
 	uploadUsecase domain.UploadUsecase
+	maxFileSize   int64
 }
 
-func NewUploadHandler(uploadUsecase domain.UploadUsecase) *UploadHandler {
+func NewUploadHandler(uploadUsecase domain.UploadUsecase, maxFileSize int64) *UploadHandler {
 	return &UploadHandler{
 		uploadUsecase: uploadUsecase,
+		maxFileSize:   maxFileSize,
 	}
 }
 
+// UploadFile streams the "data" part of a multipart body straight into storage.
+// The body is capped at the configured maximum file size (plus room for the
+// multipart framing) so oversized requests fail with 413 while they are read.
 func (h *UploadHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
 	userID, ok := r.Context().Value("user_id").(int)
@@ -27,76 +40,203 @@ func (h *UploadHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form (32MB max memory)
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	bodyLimit := h.maxFileSize + multipartOverhead
+	if r.ContentLength > bodyLimit {
+		respondError(w, domain.ErrFileTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Unable to parse form")
 		return
 	}
 
-	// Get file from form
-	file, handler, err := r.FormFile("data")
+	// Skip ahead to the file part
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			utils.RespondError(w, http.StatusBadRequest, "No file provided")
+			return
+		}
+		if err != nil {
+			respondError(w, fmt.Errorf("%w: %v", errMalformedBody, err))
+			return
+		}
+		if part.FormName() == "data" && part.FileName() != "" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
+
+	// Store file and save metadata to database
+	upload, err := h.uploadUsecase.UploadFile(
+		r.Context(),
+		userID,
+		part.FileName(),
+		part.Header.Get("Content-Type"),
+		part,
+		r.UserAgent(),
+		r.RemoteAddr,
+	)
 	if err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "No file provided")
+		respondError(w, err)
 		return
 	}
-	defer file.Close()
 
-	// Check file size (8MB limit)
-	const maxSize = 8 * 1024 * 1024
-	if handler.Size > maxSize {
-		utils.RespondError(w, http.StatusBadRequest, "File size exceeds 8MB limit")
+	utils.RespondJSON(w, http.StatusOK, upload)
+}
+
+func (h *UploadHandler) ListUploads(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	// Check content type
-	contentType := handler.Header.Get("Content-Type")
-	if contentType == "" {
-		// Try to detect content type
-		buffer := make([]byte, 512)
-		_, err := file.Read(buffer)
-		if err != nil {
-			utils.RespondError(w, http.StatusInternalServerError, "Unable to read file")
+	params := r.URL.Query()
+	query := &domain.UploadListQuery{
+		Cursor:      params.Get("cursor"),
+		SortBy:      params.Get("sort"),
+		Order:       params.Get("order"),
+		ContentType: params.Get("content_type"),
+		CreatedFrom: params.Get("created_from"),
+		CreatedTo:   params.Get("created_to"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid limit")
 			return
 		}
-		contentType = http.DetectContentType(buffer)
-		file.Seek(0, 0) // Reset file pointer
 	}
 
-	// Create temp file
-	tempFile, err := os.CreateTemp("/tmp", "upload_*"+filepath.Ext(handler.Filename))
+	page, err := h.uploadUsecase.ListUploads(r.Context(), userID, query)
 	if err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, "Unable to create temp file")
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+func (h *UploadHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
-	defer tempFile.Close()
+	role, _ := r.Context().Value("role").(string)
 
-	// Copy file content
-	_, err = io.Copy(tempFile, file)
+	uploadID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, "Unable to save file")
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
 		return
 	}
 
-	// Save metadata to database
-	upload, err := h.uploadUsecase.UploadFile(
-		userID,
-		handler.Filename,
-		contentType,
-		handler.Size,
-		tempFile.Name(),
-		r.UserAgent(),
-		r.RemoteAddr,
-	)
+	upload, err := h.uploadUsecase.GetUpload(r.Context(), userID, role, uploadID)
 	if err != nil {
-		// Clean up temp file on error
-		os.Remove(tempFile.Name())
-		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		respondError(w, err)
 		return
 	}
 
 	utils.RespondJSON(w, http.StatusOK, upload)
 }
 
+func (h *UploadHandler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	uploadID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+
+	if err := h.uploadUsecase.DeleteUpload(r.Context(), userID, role, uploadID); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DownloadFile streams an upload back to its owner (or an admin). Range requests and
+// conditional requests are handled by http.ServeContent using the ETag set here.
+func (h *UploadHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	uploadID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+
+	upload, content, err := h.uploadUsecase.OpenUpload(r.Context(), userID, role, uploadID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer content.Close()
+
+	// Upload content never changes once stored, so its checksum (or, for uploads
+	// stored before checksums were recorded, the record identity) is a strong validator
+	etag := upload.StoredSHA256
+	if etag == "" {
+		etag = fmt.Sprintf("%d-%d-%d", upload.ID, upload.Size, upload.CreatedAt.UnixNano())
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+	w.Header().Set("Content-Type", upload.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": upload.Filename}))
+
+	http.ServeContent(w, r, upload.Filename, upload.CreatedAt, content)
+}
+
+func (h *UploadHandler) DownloadVariant(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	vars := mux.Vars(r)
+	uploadID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload id")
+		return
+	}
+
+	variant, content, err := h.uploadUsecase.OpenVariant(r.Context(), userID, role, uploadID, vars["name"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer content.Close()
+
+	// A variant is only replaced by regenerating it, which gives it a new timestamp
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d-%d"`, variant.ID, variant.Size, variant.CreatedAt.UnixNano()))
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+	w.Header().Set("Content-Type", variant.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", variant.CreatedAt, content)
+}
+
 func (h *UploadHandler) ServeUploadForm(w http.ResponseWriter, r *http.Request) {
 	html := `
     <!DOCTYPE html>
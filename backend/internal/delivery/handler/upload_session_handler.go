@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+// chunkContentType is the media type of PATCH bodies, as in the tus protocol
+const chunkContentType = "application/offset+octet-stream"
+
+// UploadSessionHandler serves resumable uploads: POST creates a session, HEAD
+// reports the received offset, PATCH appends a chunk at that offset and
+// POST .../complete turns the assembled file into a regular upload.
+type UploadSessionHandler struct {
+	sessionUsecase domain.UploadSessionUsecase
+}
+
+func NewUploadSessionHandler(sessionUsecase domain.UploadSessionUsecase) *UploadSessionHandler {
+	return &UploadSessionHandler{
+		sessionUsecase: sessionUsecase,
+	}
+}
+
+func (h *UploadSessionHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req domain.CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	session, err := h.sessionUsecase.CreateSession(r.Context(), userID, &req)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/sessions/"+session.ID)
+	setSessionHeaders(w, session)
+	utils.RespondJSON(w, http.StatusCreated, session)
+}
+
+func (h *UploadSessionHandler) HeadSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	session, err := h.sessionUsecase.GetSession(r.Context(), userID, mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	setSessionHeaders(w, session)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UploadSessionHandler) AppendChunk(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != chunkContentType {
+		utils.RespondError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+chunkContentType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid Upload-Offset header")
+		return
+	}
+
+	session, err := h.sessionUsecase.AppendChunk(r.Context(), userID, mux.Vars(r)["id"], offset, r.Body)
+	if session != nil {
+		setSessionHeaders(w, session)
+	}
+	if err != nil {
+		// The bytes received before the body broke off are kept; the client
+		// resumes from the Upload-Offset reported here or by HEAD
+		if session != nil {
+			err = fmt.Errorf("%w: %v", errMalformedBody, err)
+		}
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UploadSessionHandler) CompleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	upload, err := h.sessionUsecase.Complete(r.Context(), userID, mux.Vars(r)["id"], r.UserAgent(), r.RemoteAddr)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, upload)
+}
+
+func (h *UploadSessionHandler) CancelSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := h.sessionUsecase.Cancel(r.Context(), userID, mux.Vars(r)["id"]); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setSessionHeaders(w http.ResponseWriter, session *domain.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
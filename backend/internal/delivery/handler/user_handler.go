@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/pkg/utils"
+)
+
+type UserHandler struct {
+	userUsecase domain.UserUsecase
+}
+
+func NewUserHandler(userUsecase domain.UserUsecase) *UserHandler {
+	return &UserHandler{
+		userUsecase: userUsecase,
+	}
+}
+
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, err := h.userUsecase.GetProfile(r.Context(), userID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, user)
+}
+
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req domain.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.userUsecase.UpdateProfile(r.Context(), userID, &req)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, user)
+}
+
+func (h *UserHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	quota, err := h.userUsecase.GetQuota(r.Context(), userID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, quota)
+}
+
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := h.userUsecase.DeleteAccount(r.Context(), userID); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
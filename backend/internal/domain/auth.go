@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type AuthRequest struct {
 	Username string `json:"username" validate:"required"`
@@ -8,26 +12,127 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token                 string    `json:"token"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenClaims.TokenVersion must match the user's current token_version for the token to be accepted
 type TokenClaims struct {
-	UserID    int    `json:"user_id"`
-	Username  string `json:"username"`
-	IssuedAt  int64  `json:"iat"`
-	ExpiresAt int64  `json:"exp"`
+	ID           string `json:"jti"`
+	UserID       int    `json:"user_id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"ver"`
+	Issuer       string `json:"iss"`
+	Audience     string `json:"aud"`
+	IssuedAt     int64  `json:"iat"`
+	NotBefore    int64  `json:"nbf"`
+	ExpiresAt    int64  `json:"exp"`
 }
 
 type RevokedToken struct {
 	ID        int       `json:"id" db:"id"`
-	Token     string    `json:"token" db:"token"`
+	TokenID   string    `json:"jti" db:"jti"`
 	RevokedAt time.Time `json:"revoked_at" db:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type RevokedTokenRepository interface {
+	Create(ctx context.Context, token *RevokedToken) error
+	Exists(ctx context.Context, tokenID string) (bool, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RefreshToken is an opaque, single-use token. Only its SHA-256 hash is stored.
+// Tokens issued by rotating one another share a FamilyID so that the whole chain
+// can be revoked when reuse of an already rotated token is detected.
+type RefreshToken struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at" db:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	// MarkUsed flags the token as rotated. It returns false if the token had already been used.
+	MarkUsed(ctx context.Context, id int, usedAt time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeAllForUser(ctx context.Context, userID int, revokedAt time.Time) error
+}
+
+// Login attempt scopes. Failures are counted separately per username and per client IP.
+const (
+	LoginScopeUsername = "username"
+	LoginScopeIP       = "ip"
+)
+
+type LoginAttempt struct {
+	ID            int        `json:"id" db:"id"`
+	Scope         string     `json:"scope" db:"scope"`
+	Key           string     `json:"key" db:"key"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until" db:"locked_until"`
+}
+
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, scope, key string) (*LoginAttempt, error)
+	// RecordFailure increments the failure counter, restarting it from one if the
+	// previous failure happened before windowStart, and returns the updated row.
+	RecordFailure(ctx context.Context, scope, key string, at time.Time, windowStart time.Time) (*LoginAttempt, error)
+	Lock(ctx context.Context, scope, key string, until time.Time) error
+	Reset(ctx context.Context, scope, key string) error
+}
+
+// LoginLockedError is returned by Login while the username or client IP is locked out
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// JSONWebKey is the public part of a token verification key, as published in the JWKS document
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
 }
 
 type AuthUsecase interface {
-	Register(req *AuthRequest) (*AuthResponse, error)
-	Login(req *AuthRequest) (*AuthResponse, error)
-	ValidateToken(token string) (*TokenClaims, error)
-	RevokeToken(token string) error
+	Register(ctx context.Context, req *AuthRequest) (*AuthResponse, error)
+	Login(ctx context.Context, req *AuthRequest, clientIP string) (*AuthResponse, error)
+	Refresh(ctx context.Context, req *RefreshRequest) (*AuthResponse, error)
+	ValidateToken(ctx context.Context, token string) (*TokenClaims, error)
+	RevokeToken(ctx context.Context, token string) error
+	ChangePassword(ctx context.Context, userID int, req *ChangePasswordRequest) (*AuthResponse, error)
+	LogoutAll(ctx context.Context, userID int) error
+	JWKS() *JSONWebKeySet
 }
@@ -0,0 +1,48 @@
+package domain
+
+import "errors"
+
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrForbidden          = errors.New("forbidden")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrTokenReused        = errors.New("refresh token reuse detected")
+	ErrFileTooLarge       = errors.New("file size exceeds the upload limit")
+	ErrOffsetMismatch     = errors.New("upload offset does not match the session")
+	ErrScanPending        = errors.New("upload is still being scanned")
+	ErrUploadInfected     = errors.New("upload is quarantined as infected")
+	ErrShareUnavailable   = errors.New("share link has expired, was revoked or has no downloads left")
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field that failed validation. It serializes
+// directly as the error response body.
+type ValidationError struct {
+	Message string       `json:"error"`
+	Fields  []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Add records a failed field
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// OrNil returns the error if any field failed, nil otherwise
+func (e *ValidationError) OrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
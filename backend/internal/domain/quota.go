@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UserQuota is a user's effective storage quota and current usage. The limits
+// come from the configured defaults unless the user has an override.
+type UserQuota struct {
+	MaxBytes       int64 `json:"max_bytes"`
+	MaxFiles       int   `json:"max_files"`
+	UsedBytes      int64 `json:"used_bytes"`
+	UsedFiles      int   `json:"used_files"`
+	RemainingBytes int64 `json:"remaining_bytes"`
+	RemainingFiles int   `json:"remaining_files"`
+}
+
+// QuotaOverride replaces the configured default limits for one user. A nil
+// limit keeps the default.
+type QuotaOverride struct {
+	UserID    int       `json:"user_id" db:"user_id"`
+	MaxBytes  *int64    `json:"max_bytes" db:"max_bytes"`
+	MaxFiles  *int      `json:"max_files" db:"max_files"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SetQuotaRequest struct {
+	MaxBytes *int64 `json:"max_bytes"`
+	MaxFiles *int   `json:"max_files"`
+}
+
+// QuotaRepository stores quota overrides and keeps a running total of every
+// user's uploads, which is changed with conditional updates so concurrent
+// uploads cannot overshoot a quota
+type QuotaRepository interface {
+	GetOverride(ctx context.Context, userID int) (*QuotaOverride, error)
+	SetOverride(ctx context.Context, override *QuotaOverride) error
+	DeleteOverride(ctx context.Context, userID int) error
+	// GetUsage returns the user's total upload size and count
+	GetUsage(ctx context.Context, userID int) (int64, int, error)
+	// Reserve adds one upload of size bytes to the usage if that keeps it within
+	// the limits, and reports sql.ErrNoRows otherwise
+	Reserve(ctx context.Context, userID int, size int64, maxBytes int64, maxFiles int) error
+	// Release removes one upload of size bytes from the usage
+	Release(ctx context.Context, userID int, size int64) error
+}
+
+// QuotaExceededError is returned when an upload would exceed the user's quota
+type QuotaExceededError struct {
+	Quota *UserQuota
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Quota.RemainingFiles <= 0 {
+		return fmt.Sprintf("upload quota exceeded: file limit of %d reached", e.Quota.MaxFiles)
+	}
+	return fmt.Sprintf("upload quota exceeded: %d of %d bytes remaining", e.Quota.RemainingBytes, e.Quota.MaxBytes)
+}
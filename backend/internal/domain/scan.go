@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"context"
+	"io"
+)
+
+// Upload scan states. Pending uploads cannot be downloaded until a scan marks
+// them clean; infected uploads stay quarantined.
+const (
+	ScanStatusPending  = "pending"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+)
+
+// ScanResult is the verdict of a Scanner. Signature names the detected malware.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner inspects content for malware
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+}
+
+// UploadScanner scans stored uploads in the background and records the verdict
+type UploadScanner interface {
+	// Enqueue schedules a pending upload and reports false if the queue is full.
+	// Uploads that are not scanned now are picked up again by Run.
+	Enqueue(upload *FileUpload) bool
+	// Run scans queued uploads, and periodically requeues pending ones, until ctx is cancelled
+	Run(ctx context.Context)
+}
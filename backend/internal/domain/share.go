@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// UploadShare is a link that lets anyone holding it download an upload without
+// an account until ExpiresAt, at most MaxDownloads times when that is set.
+// UserID is the upload's owner and CreatedBy whoever created the link, which may
+// be an admin; it is nil for links created before it was recorded or whose
+// creator was deleted. URL is derived from the signed token and not stored.
+type UploadShare struct {
+	ID            int        `json:"id" db:"id"`
+	UploadID      int        `json:"upload_id" db:"upload_id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	CreatedBy     *int       `json:"created_by" db:"created_by"`
+	URL           string     `json:"url" db:"-"`
+	ExpiresAt     time.Time  `json:"expires_at" db:"expires_at"`
+	MaxDownloads  *int       `json:"max_downloads" db:"max_downloads"`
+	DownloadCount int        `json:"download_count" db:"download_count"`
+	RevokedAt     *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateShareRequest leaves ExpiresAt at the configured default when it is
+// omitted and the downloads unlimited when MaxDownloads is
+type CreateShareRequest struct {
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxDownloads *int       `json:"max_downloads"`
+}
+
+type UploadShareRepository interface {
+	Create(ctx context.Context, share *UploadShare) error
+	GetByID(ctx context.Context, id int) (*UploadShare, error)
+	ListByUploadID(ctx context.Context, uploadID int) ([]*UploadShare, error)
+	Revoke(ctx context.Context, id int, revokedAt time.Time) error
+	// RecordDownload counts a download and returns the updated share, reporting
+	// sql.ErrNoRows if the share is revoked, expired or out of downloads
+	RecordDownload(ctx context.Context, id int, now time.Time) (*UploadShare, error)
+}
+
+type ShareUsecase interface {
+	// CreateShare, ListShares and RevokeShare act on uploads visible to the caller
+	CreateShare(ctx context.Context, userID int, role string, uploadID int, req *CreateShareRequest) (*UploadShare, error)
+	ListShares(ctx context.Context, userID int, role string, uploadID int) ([]*UploadShare, error)
+	RevokeShare(ctx context.Context, userID int, role string, uploadID int, shareID int) error
+	// OpenShare verifies the token and returns the share with the shared upload's
+	// content. Links with a download limit have the download counted here, so
+	// every request uses one up; for other links the caller records it with
+	// RecordDownload. Unknown tokens are reported as ErrNotFound, and links that
+	// were revoked, expired or used up, or whose upload belongs to a disabled
+	// account, as ErrShareUnavailable.
+	OpenShare(ctx context.Context, token string) (*UploadShare, *FileUpload, io.ReadSeekCloser, error)
+	// RecordDownload counts a download of the share, reporting ErrShareUnavailable
+	// if the link was revoked, expired or used up in the meantime
+	RecordDownload(ctx context.Context, shareID int) error
+}
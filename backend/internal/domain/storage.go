@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore stores upload content under opaque, slash-separated keys.
+// Get, Stat and Delete report missing blobs as ErrBlobNotFound.
+type BlobStore interface {
+	// Put writes the whole reader under key, replacing any existing blob, and
+	// returns the number of bytes stored
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every stored blob, in no particular order, and stops at
+	// the first error fn returns
+	Walk(ctx context.Context, fn func(info *BlobInfo) error) error
+}
+
+// Blob is content shared by every upload with the same SHA-256. Key is where
+// the content lives in the BlobStore and RefCount how many uploads use it.
+type Blob struct {
+	Hash      string    `json:"hash" db:"hash"`
+	Key       string    `json:"key" db:"file_path"`
+	Size      int64     `json:"size" db:"size"`
+	RefCount  int       `json:"ref_count" db:"ref_count"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type BlobRepository interface {
+	// Acquire adds a reference to the blob, creating it with one reference when
+	// it does not exist yet, and reports whether it was created
+	Acquire(ctx context.Context, blob *Blob) (bool, error)
+	// Release drops a reference and returns the blob with its remaining count
+	Release(ctx context.Context, hash string) (*Blob, error)
+	// DeleteUnreferenced removes the blob record if nothing references it
+	DeleteUnreferenced(ctx context.Context, hash string) error
+	// ListAfterHash returns up to limit blobs ordered by hash, starting after afterHash
+	ListAfterHash(ctx context.Context, afterHash string, limit int) ([]*Blob, error)
+}
+
+// StorageReport lists what a StorageReconciler found, and removed unless DryRun
+// is set. OrphanedFiles are stored blobs nothing refers to, DanglingUploads and
+// DanglingVariants records whose content is missing, and UnreferencedBlobs blob
+// records left without references.
+type StorageReport struct {
+	DryRun            bool     `json:"dry_run"`
+	OrphanedFiles     []string `json:"orphaned_files"`
+	OrphanedBytes     int64    `json:"orphaned_bytes"`
+	DanglingUploads   []int    `json:"dangling_uploads"`
+	DanglingVariants  []int    `json:"dangling_variants"`
+	UnreferencedBlobs []string `json:"unreferenced_blobs"`
+}
+
+// StorageReconciler brings the blob store and the upload records back in line
+// after crashes or manual changes to either
+type StorageReconciler interface {
+	Reconcile(ctx context.Context, dryRun bool) (*StorageReport, error)
+}
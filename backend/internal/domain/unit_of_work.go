@@ -0,0 +1,19 @@
+package domain
+
+import "context"
+
+// TxRepositories are repositories whose statements all run in the same transaction
+type TxRepositories struct {
+	Users    UserRepository
+	Uploads  UploadRepository
+	Blobs    BlobRepository
+	Quotas   QuotaRepository
+	Variants FileUploadVariantRepository
+}
+
+// UnitOfWork makes a group of repository calls atomic
+type UnitOfWork interface {
+	// Do runs fn in a transaction, committing it when fn returns nil and rolling
+	// it back when fn returns an error or panics
+	Do(ctx context.Context, fn func(repos *TxRepositories) error) error
+}
@@ -1,25 +1,118 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"io"
+	"time"
+)
 
+// FileUpload.FilePath is the key of the upload's content in the BlobStore.
+// ChecksumSHA256 is the hex SHA-256 of the file as the client sent it, so clients
+// can verify what they uploaded, and StoredSHA256 that of the stored content, which
+// differs when metadata was stripped. Content is deduplicated by StoredSHA256.
+// ContentType, Width and Height are read from the image itself, not the client.
+// CameraMake, CameraModel and TakenAt come from EXIF data when uploads are sanitized.
+// ScanStatus is one of the ScanStatus constants and ScanSignature names the
+// malware found in infected uploads.
 type FileUpload struct {
-	ID          int       `json:"id" db:"id"`
-	Filename    string    `json:"filename" db:"filename"`
-	ContentType string    `json:"content_type" db:"content_type"`
-	Size        int64     `json:"size" db:"size"`
-	FilePath    string    `json:"file_path" db:"file_path"`
-	UserAgent   string    `json:"user_agent" db:"user_agent"`
-	RemoteAddr  string    `json:"remote_addr" db:"remote_addr"`
-	UserID      int       `json:"user_id" db:"user_id"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID             int        `json:"id" db:"id"`
+	Filename       string     `json:"filename" db:"filename"`
+	ContentType    string     `json:"content_type" db:"content_type"`
+	Size           int64      `json:"size" db:"size"`
+	FilePath       string     `json:"file_path" db:"file_path"`
+	ChecksumSHA256 string     `json:"checksum_sha256" db:"checksum_sha256"`
+	StoredSHA256   string     `json:"stored_sha256" db:"stored_sha256"`
+	Width          int        `json:"width" db:"width"`
+	Height         int        `json:"height" db:"height"`
+	CameraMake     string     `json:"camera_make,omitempty" db:"camera_make"`
+	CameraModel    string     `json:"camera_model,omitempty" db:"camera_model"`
+	TakenAt        *time.Time `json:"taken_at,omitempty" db:"taken_at"`
+	ScanStatus     string     `json:"scan_status" db:"scan_status"`
+	ScanSignature  string     `json:"scan_signature,omitempty" db:"scan_signature"`
+	ScannedAt      *time.Time `json:"scanned_at,omitempty" db:"scanned_at"`
+	UserAgent      string     `json:"user_agent" db:"user_agent"`
+	RemoteAddr     string     `json:"remote_addr" db:"remote_addr"`
+	UserID         int        `json:"user_id" db:"user_id"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Upload list sort keys
+const (
+	UploadSortCreatedAt = "created_at"
+	UploadSortSize      = "size"
+)
+
+// UploadListQuery holds the raw query parameters of GET /uploads
+type UploadListQuery struct {
+	Cursor      string
+	Limit       int
+	SortBy      string
+	Order       string
+	ContentType string
+	CreatedFrom string
+	CreatedTo   string
+}
+
+// UploadFilter is a validated UploadListQuery for the repository. After, when set,
+// is the last row of the previous page; results continue strictly past it in sort order.
+type UploadFilter struct {
+	UserID      int
+	ContentType string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	SortBy      string
+	Descending  bool
+	After       *UploadCursor
+	Limit       int
+}
+
+// UploadCursor identifies the last row of a page. It remembers the ordering it was
+// issued for so that it cannot be replayed against a different sort.
+type UploadCursor struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int64     `json:"size"`
+	SortBy     string    `json:"sort_by"`
+	Descending bool      `json:"desc"`
+}
+
+type UploadPage struct {
+	Items      []*FileUpload `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
 }
 
 type UploadRepository interface {
-	Create(upload *FileUpload) error
-	GetByID(id int) (*FileUpload, error)
+	Create(ctx context.Context, upload *FileUpload) error
+	GetByID(ctx context.Context, id int) (*FileUpload, error)
+	Find(ctx context.Context, filter UploadFilter) ([]*FileUpload, error)
+	ListByUserID(ctx context.Context, userID int) ([]*FileUpload, error)
+	List(ctx context.Context, limit, offset int) ([]*FileUpload, error)
+	// ListByScanStatus returns up to limit uploads in the given scan state ordered
+	// by ID, starting after afterID
+	ListByScanStatus(ctx context.Context, status string, afterID int, limit int) ([]*FileUpload, error)
+	SetScanResult(ctx context.Context, id int, status string, signature string, scannedAt time.Time) error
+	// ListMissingVariants returns up to limit clean uploads lacking a variant
+	// with one of the given names, ordered by ID and starting after afterID
+	ListMissingVariants(ctx context.Context, names []string, afterID int, limit int) ([]*FileUpload, error)
+	// ListAfterID returns up to limit uploads of all users ordered by ID, starting after afterID
+	ListAfterID(ctx context.Context, afterID int, limit int) ([]*FileUpload, error)
+	Delete(ctx context.Context, id int) error
 }
 
 type UploadUsecase interface {
-	UploadFile(userID int, filename string, contentType string, size int64,
-		filePath string, userAgent string, remoteAddr string) (*FileUpload, error)
+	// UploadFile streams content into storage. The image format is detected from the
+	// content and must match the declared type, reading more than the configured
+	// maximum size fails with ErrFileTooLarge, and variants are generated afterwards.
+	UploadFile(ctx context.Context, userID int, filename string, contentType string,
+		content io.Reader, userAgent string, remoteAddr string) (*FileUpload, error)
+	// GetUpload returns the upload if it belongs to userID or role is admin.
+	// Uploads of other users are reported as ErrNotFound.
+	GetUpload(ctx context.Context, userID int, role string, uploadID int) (*FileUpload, error)
+	// OpenUpload returns the content of an upload that was scanned clean, and
+	// ErrScanPending or ErrUploadInfected for uploads that were not
+	OpenUpload(ctx context.Context, userID int, role string, uploadID int) (*FileUpload, io.ReadSeekCloser, error)
+	// OpenVariant returns a generated variant of an upload visible to the caller
+	OpenVariant(ctx context.Context, userID int, role string, uploadID int, name string) (*FileUploadVariant, io.ReadSeekCloser, error)
+	ListUploads(ctx context.Context, userID int, query *UploadListQuery) (*UploadPage, error)
+	DeleteUpload(ctx context.Context, userID int, role string, uploadID int) error
 }
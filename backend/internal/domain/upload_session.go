@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// UploadSession is a resumable upload in progress. Size is the declared total
+// length and Offset how many bytes have been received so far; sessions that see
+// no progress until ExpiresAt are discarded.
+type UploadSession struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	Offset      int64     `json:"offset" db:"upload_offset"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type CreateUploadSessionRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+type UploadSessionRepository interface {
+	Create(ctx context.Context, session *UploadSession) error
+	GetByID(ctx context.Context, id string) (*UploadSession, error)
+	// UpdateOffset moves the offset from one value to another and reports
+	// sql.ErrNoRows if the session is no longer at from
+	UpdateOffset(ctx context.Context, id string, from int64, to int64, expiresAt time.Time) error
+	// Lock leases the user's session to the holder of token until until, unless
+	// another lease is still running at now, and returns the session.
+	// sql.ErrNoRows means the session does not exist or is held.
+	Lock(ctx context.Context, id string, userID int, token string, now time.Time, until time.Time) (*UploadSession, error)
+	// Unlock ends the lease taken with token, if it is still held with it
+	Unlock(ctx context.Context, id string, token string) error
+	Delete(ctx context.Context, id string) error
+	ListExpired(ctx context.Context, before time.Time) ([]*UploadSession, error)
+	// CountOpen returns how many of the user's sessions are still live at now and
+	// the sum of their declared sizes
+	CountOpen(ctx context.Context, userID int, now time.Time) (int, int64, error)
+}
+
+type UploadSessionUsecase interface {
+	CreateSession(ctx context.Context, userID int, req *CreateUploadSessionRequest) (*UploadSession, error)
+	GetSession(ctx context.Context, userID int, id string) (*UploadSession, error)
+	// AppendChunk writes a chunk that must start at the session's current offset
+	// and returns the session with its new offset
+	AppendChunk(ctx context.Context, userID int, id string, offset int64, chunk io.Reader) (*UploadSession, error)
+	// Complete runs the assembled content through the regular upload pipeline
+	Complete(ctx context.Context, userID int, id string, userAgent string, remoteAddr string) (*FileUpload, error)
+	Cancel(ctx context.Context, userID int, id string) error
+	PurgeExpiredSessions(ctx context.Context) (int, error)
+}
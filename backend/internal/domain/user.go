@@ -1,17 +1,71 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User.TokenVersion is embedded in every access token and bumped to invalidate
+// all tokens issued so far (password change, logout everywhere)
 type User struct {
-	ID        int       `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Password  string    `json:"-" db:"password"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID           int        `json:"id" db:"id"`
+	Username     string     `json:"username" db:"username"`
+	Password     string     `json:"-" db:"password"`
+	Role         string     `json:"role" db:"role"`
+	TokenVersion int        `json:"-" db:"token_version"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+}
+
+type SetDisabledRequest struct {
+	Disabled bool `json:"disabled"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required"`
 }
 
 type UserRepository interface {
-	Create(user *User) error
-	GetByUsername(username string) (*User, error)
-	GetByID(id int) (*User, error)
+	Create(ctx context.Context, user *User) error
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id int) error
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	GetByID(ctx context.Context, id int) (*User, error)
+	List(ctx context.Context, limit, offset int) ([]*User, error)
+	UpdatePassword(ctx context.Context, id int, password string) (int, error)
+	IncrementTokenVersion(ctx context.Context, id int) (int, error)
+	SetRole(ctx context.Context, id int, role string) error
+	SetDisabledAt(ctx context.Context, id int, disabledAt *time.Time) error
+}
+
+type UserUsecase interface {
+	GetProfile(ctx context.Context, userID int) (*User, error)
+	UpdateProfile(ctx context.Context, userID int, req *UpdateProfileRequest) (*User, error)
+	DeleteAccount(ctx context.Context, userID int) error
+	GetQuota(ctx context.Context, userID int) (*UserQuota, error)
+}
+
+// AdminUsecase backs the admin-only routes
+type AdminUsecase interface {
+	ListUsers(ctx context.Context, limit, offset int) ([]*User, error)
+	SetUserDisabled(ctx context.Context, userID int, disabled bool) (*User, error)
+	SetUserRole(ctx context.Context, userID int, role string) (*User, error)
+	ListUploads(ctx context.Context, limit, offset int) ([]*FileUpload, error)
+	// SetUserQuota overrides the default quota of a user; nil limits fall back to the defaults
+	SetUserQuota(ctx context.Context, userID int, req *SetQuotaRequest) (*UserQuota, error)
+	// CreateAdmin registers a user with the admin role, reporting ErrUserExists
+	// if the username is taken
+	CreateAdmin(ctx context.Context, username string, password string) (*User, error)
+	// PromoteAdmin gives an existing user the admin role
+	PromoteAdmin(ctx context.Context, username string) (*User, error)
 }
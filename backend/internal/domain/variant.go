@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// FileUploadVariant is a resized rendition of an upload, generated in the
+// background after the upload is stored. FilePath is its BlobStore key.
+type FileUploadVariant struct {
+	ID          int       `json:"id" db:"id"`
+	UploadID    int       `json:"upload_id" db:"upload_id"`
+	Name        string    `json:"name" db:"name"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Width       int       `json:"width" db:"width"`
+	Height      int       `json:"height" db:"height"`
+	Size        int64     `json:"size" db:"size"`
+	FilePath    string    `json:"-" db:"file_path"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type FileUploadVariantRepository interface {
+	// Upsert records the variant, replacing an earlier one with the same name
+	Upsert(ctx context.Context, variant *FileUploadVariant) error
+	GetByUploadAndName(ctx context.Context, uploadID int, name string) (*FileUploadVariant, error)
+	ListByUploadID(ctx context.Context, uploadID int) ([]*FileUploadVariant, error)
+	// ListAfterID returns up to limit variants ordered by ID, starting after afterID
+	ListAfterID(ctx context.Context, afterID int, limit int) ([]*FileUploadVariant, error)
+	Delete(ctx context.Context, id int) error
+}
+
+// VariantGenerator renders the configured variants of uploads on a bounded
+// pool of workers
+type VariantGenerator interface {
+	// Enqueue schedules the upload and reports false if the queue is full
+	Enqueue(upload *FileUpload) bool
+	// Run processes queued uploads until ctx is cancelled
+	Run(ctx context.Context)
+}
@@ -74,9 +74,134 @@ func RunMigrations(db *sql.DB) error {
 		)`,
 		`CREATE TABLE IF NOT EXISTS revoked_tokens (
 			id SERIAL PRIMARY KEY,
-			token TEXT NOT NULL,
 			revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`ALTER TABLE revoked_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at)`,
+		`ALTER TABLE revoked_tokens ADD COLUMN IF NOT EXISTS jti VARCHAR(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_revoked_tokens_jti ON revoked_tokens (jti)`,
+		// Revocations recorded by the whole token before tokens carried a jti are
+		// never looked up, and those without an expiry would never be purged
+		`DELETE FROM revoked_tokens WHERE jti IS NULL OR expires_at IS NULL`,
+		`DROP INDEX IF EXISTS idx_revoked_tokens_token`,
+		`ALTER TABLE revoked_tokens DROP COLUMN IF EXISTS token`,
+		`ALTER TABLE revoked_tokens ALTER COLUMN jti SET NOT NULL`,
+		`ALTER TABLE revoked_tokens ALTER COLUMN expires_at SET NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			family_id VARCHAR(64) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id)`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			id SERIAL PRIMARY KEY,
+			scope VARCHAR(16) NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP,
+			UNIQUE (scope, key)
+		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
+		// Only rebuilt while it does not cascade yet, as that locks both tables
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint
+			               WHERE conname = 'file_uploads_user_id_fkey' AND confdeltype = 'c') THEN
+				ALTER TABLE file_uploads DROP CONSTRAINT IF EXISTS file_uploads_user_id_fkey,
+					ADD CONSTRAINT file_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+			END IF;
+		END $$`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_user_id ON file_uploads (user_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_user_created ON file_uploads (user_id, created_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_user_size ON file_uploads (user_id, size, id)`,
+		`ALTER TABLE file_uploads ADD COLUMN IF NOT EXISTS checksum_sha256 VARCHAR(64)`,
+		`ALTER TABLE file_uploads ADD COLUMN IF NOT EXISTS width INTEGER,
+			ADD COLUMN IF NOT EXISTS height INTEGER`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			hash VARCHAR(64) PRIMARY KEY,
+			file_path VARCHAR(500) NOT NULL,
+			size BIGINT NOT NULL,
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_checksum ON file_uploads (checksum_sha256)`,
+		`CREATE TABLE IF NOT EXISTS upload_sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(100) NOT NULL DEFAULT '',
+			size BIGINT NOT NULL,
+			upload_offset BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions (expires_at)`,
+		`CREATE TABLE IF NOT EXISTS file_upload_variants (
+			id SERIAL PRIMARY KEY,
+			upload_id INTEGER NOT NULL REFERENCES file_uploads(id) ON DELETE CASCADE,
+			name VARCHAR(32) NOT NULL,
+			content_type VARCHAR(100) NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			size BIGINT NOT NULL,
+			file_path VARCHAR(500) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (upload_id, name)
+		)`,
+		`ALTER TABLE file_uploads ADD COLUMN IF NOT EXISTS camera_make VARCHAR(100),
+			ADD COLUMN IF NOT EXISTS camera_model VARCHAR(100),
+			ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS user_quotas (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			max_bytes BIGINT,
+			max_files INTEGER,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_storage_usage (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			used_bytes BIGINT NOT NULL DEFAULT 0,
+			used_files INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE file_uploads ADD COLUMN IF NOT EXISTS scan_status VARCHAR(16) NOT NULL DEFAULT 'clean',
+			ADD COLUMN IF NOT EXISTS scan_signature VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_scan_status ON file_uploads (scan_status)`,
+		`CREATE TABLE IF NOT EXISTS upload_shares (
+			id SERIAL PRIMARY KEY,
+			upload_id INTEGER NOT NULL REFERENCES file_uploads(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP NOT NULL,
+			max_downloads INTEGER,
+			download_count INTEGER NOT NULL DEFAULT 0,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_shares_upload_id ON upload_shares (upload_id)`,
+		// Uploads stored before this column was added have checksum_sha256 as their stored hash
+		`ALTER TABLE file_uploads ADD COLUMN IF NOT EXISTS stored_sha256 VARCHAR(64)`,
+		`DROP INDEX IF EXISTS idx_file_uploads_scan_status`,
+		`CREATE INDEX IF NOT EXISTS idx_file_uploads_scan_status_id ON file_uploads (scan_status, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_sessions_user_id ON upload_sessions (user_id)`,
+		// The request writing to a session holds it until locked_until, on any instance
+		`ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS lock_token VARCHAR(64),
+			ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP`,
+		// user_id is the upload's owner; links an admin created for someone else's
+		// upload recorded the admin there, who becomes their creator
+		`ALTER TABLE upload_shares ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+		`UPDATE upload_shares s SET created_by = s.user_id, user_id = f.user_id
+			FROM file_uploads f WHERE f.id = s.upload_id AND s.user_id <> f.user_id`,
 	}
 
 	for i, migration := range migrations {
@@ -1,54 +1,161 @@
 package jwt
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/xarcher/backend/config"
 	"github.com/xarcher/backend/internal/domain"
 )
 
 type JWTService interface {
 	GenerateToken(claims *domain.TokenClaims) (string, error)
-	ValidateToken(tokenString string) (*domain.TokenClaims, error)
-	RevokeToken(token string) error
+	ValidateToken(ctx context.Context, tokenString string) (*domain.TokenClaims, error)
+	RevokeToken(ctx context.Context, token string) error
+	PurgeExpiredRevocations(ctx context.Context) (int64, error)
+	JWKS() *domain.JSONWebKeySet
 }
 
+// jwtService signs with HS256 and secretKey unless asymmetric keys are configured,
+// in which case tokens are signed with signingKey and verified by the key named
+// in their kid header. HMAC tokens are rejected once asymmetric keys are in use.
 type jwtService struct {
-	secretKey     []byte
-	revokedTokens map[string]time.Time // In production, use Redis or database
+	secretKey        []byte
+	signingKey       *signingKey
+	verificationKeys map[string]*signingKey
+	issuer           string
+	audience         string
+	revokedTokenRepo domain.RevokedTokenRepository
 }
 
-func NewJWTService(secretKey string) JWTService {
-	return &jwtService{
-		secretKey:     []byte(secretKey),
-		revokedTokens: make(map[string]time.Time),
+// tokenClaims is the wire format of our access tokens
+type tokenClaims struct {
+	UserID       int    `json:"user_id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+func NewJWTService(cfg config.JWTConfig, revokedTokenRepo domain.RevokedTokenRepository) (JWTService, error) {
+	service := &jwtService{
+		secretKey:        []byte(cfg.SecretKey),
+		verificationKeys: make(map[string]*signingKey),
+		issuer:           cfg.Issuer,
+		audience:         cfg.Audience,
+		revokedTokenRepo: revokedTokenRepo,
+	}
+
+	for _, keyCfg := range cfg.Keys {
+		key, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+		service.verificationKeys[key.id] = key
+
+		if key.id == cfg.SigningKeyID {
+			service.signingKey = key
+		}
+	}
+
+	if len(service.verificationKeys) > 0 && service.signingKey == nil {
+		return nil, errors.New("JWT signing key not found among configured keys")
 	}
+
+	return service, nil
 }
 
+// GenerateToken signs the given claims. Issuer and audience are always taken from
+// the service configuration, and a random token ID is assigned when none is set.
 func (j *jwtService) GenerateToken(claims *domain.TokenClaims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  claims.UserID,
-		"username": claims.Username,
-		"iat":      claims.IssuedAt,
-		"exp":      claims.ExpiresAt,
-	})
+	if claims.ID == "" {
+		id, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims.ID = id
+	}
+	claims.Issuer = j.issuer
+	claims.Audience = j.audience
+
+	registered := tokenClaims{
+		UserID:       claims.UserID,
+		Username:     claims.Username,
+		Role:         claims.Role,
+		TokenVersion: claims.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        claims.ID,
+			Issuer:    claims.Issuer,
+			Audience:  jwt.ClaimStrings{claims.Audience},
+			IssuedAt:  jwt.NewNumericDate(time.Unix(claims.IssuedAt, 0)),
+			NotBefore: jwt.NewNumericDate(time.Unix(claims.NotBefore, 0)),
+			ExpiresAt: jwt.NewNumericDate(time.Unix(claims.ExpiresAt, 0)),
+		},
+	}
+
+	if j.signingKey == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, registered).SignedString(j.secretKey)
+	}
 
-	return token.SignedString(j.secretKey)
+	token := jwt.NewWithClaims(j.signingKey.method, registered)
+	token.Header["kid"] = j.signingKey.id
+	return token.SignedString(j.signingKey.privateKey)
 }
 
-func (j *jwtService) ValidateToken(tokenString string) (*domain.TokenClaims, error) {
+func (j *jwtService) ValidateToken(ctx context.Context, tokenString string) (*domain.TokenClaims, error) {
+	claims, err := j.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if token is revoked
-	if _, revoked := j.revokedTokens[tokenString]; revoked {
-		return nil, errors.New("token has been revoked")
+	revoked, err := j.revokedTokenRepo.Exists(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: token has been revoked", domain.ErrInvalidToken)
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return j.secretKey, nil
+	return claims, nil
+}
+
+func (j *jwtService) RevokeToken(ctx context.Context, token string) error {
+	claims, err := j.parseToken(token)
+	if err != nil {
+		return err
+	}
+
+	return j.revokedTokenRepo.Create(ctx, &domain.RevokedToken{
+		TokenID:   claims.ID,
+		RevokedAt: time.Now(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
 	})
+}
+
+// PurgeExpiredRevocations deletes revocation records whose tokens have already expired
+func (j *jwtService) PurgeExpiredRevocations(ctx context.Context) (int64, error) {
+	return j.revokedTokenRepo.DeleteExpired(ctx, time.Now())
+}
+
+// parseToken verifies the signature and the exp, nbf, iat, iss and aud claims.
+// It does not consult the revocation list. Every failure wraps domain.ErrInvalidToken.
+func (j *jwtService) parseToken(tokenString string) (*domain.TokenClaims, error) {
+	claims, err := j.parseClaims(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (j *jwtService) parseClaims(tokenString string) (*domain.TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &tokenClaims{}, j.verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -58,20 +165,76 @@ func (j *jwtService) ValidateToken(tokenString string) (*domain.TokenClaims, err
 		return nil, errors.New("invalid token")
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
+	claims, ok := token.Claims.(*tokenClaims)
 	if !ok {
 		return nil, errors.New("invalid token claims")
 	}
 
+	if claims.ID == "" || claims.ExpiresAt == nil || claims.IssuedAt == nil || claims.NotBefore == nil {
+		return nil, errors.New("invalid token claims")
+	}
+
+	if !claims.VerifyIssuer(j.issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	if !claims.VerifyAudience(j.audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
+
 	return &domain.TokenClaims{
-		UserID:    int(claims["user_id"].(float64)),
-		Username:  claims["username"].(string),
-		IssuedAt:  int64(claims["iat"].(float64)),
-		ExpiresAt: int64(claims["exp"].(float64)),
+		ID:           claims.ID,
+		UserID:       claims.UserID,
+		Username:     claims.Username,
+		Role:         claims.Role,
+		TokenVersion: claims.TokenVersion,
+		Issuer:       claims.Issuer,
+		Audience:     j.audience,
+		IssuedAt:     claims.IssuedAt.Unix(),
+		NotBefore:    claims.NotBefore.Unix(),
+		ExpiresAt:    claims.ExpiresAt.Unix(),
 	}, nil
 }
 
-func (j *jwtService) RevokeToken(token string) error {
-	j.revokedTokens[token] = time.Now()
-	return nil
+// JWKS returns the public verification keys. It is empty when tokens are signed with HS256.
+func (j *jwtService) JWKS() *domain.JSONWebKeySet {
+	set := &domain.JSONWebKeySet{Keys: make([]domain.JSONWebKey, 0, len(j.verificationKeys))}
+	for _, key := range j.verificationKeys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	sort.Slice(set.Keys, func(a, b int) bool {
+		return set.Keys[a].KeyID < set.Keys[b].KeyID
+	})
+	return set
+}
+
+// verificationKey is the jwt.Keyfunc that picks the key matching the token's kid
+// and makes sure the token's algorithm is the one that key was issued for.
+func (j *jwtService) verificationKey(token *jwt.Token) (interface{}, error) {
+	if len(j.verificationKeys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return j.secretKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := j.verificationKeys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.publicKey, nil
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// signingKey is an asymmetric key identified by its kid. privateKey is nil for
+// keys that are only kept around to verify tokens issued before a rotation.
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+}
+
+// loadKey reads a key pair from PEM files. When a private key is given the
+// public key is derived from it and public_key_file may be omitted.
+func loadKey(cfg config.JWTKeyConfig) (*signingKey, error) {
+	key := &signingKey{id: cfg.ID}
+
+	if cfg.PrivateKeyFile != "" {
+		privateKey, err := readPEMKey(cfg.PrivateKeyFile, parsePrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey = privateKey
+
+		switch k := privateKey.(type) {
+		case *rsa.PrivateKey:
+			key.publicKey = &k.PublicKey
+		case ed25519.PrivateKey:
+			key.publicKey = k.Public()
+		}
+	}
+
+	if cfg.PublicKeyFile != "" {
+		publicKey, err := readPEMKey(cfg.PublicKeyFile, parsePublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.publicKey = publicKey
+	}
+
+	switch key.publicKey.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("key %q: unsupported key type, expected RSA or Ed25519", cfg.ID)
+	}
+
+	return key, nil
+}
+
+// jwk returns the public half of the key in JSON Web Key format
+func (k *signingKey) jwk() domain.JSONWebKey {
+	jwk := domain.JSONWebKey{
+		KeyID:     k.id,
+		Use:       "sig",
+		Algorithm: k.method.Alg(),
+	}
+
+	switch pub := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+func readPEMKey[T any](path string, parse func(block *pem.Block) (T, error)) (T, error) {
+	var zero T
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return zero, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return zero, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := parse(block)
+	if err != nil {
+		return zero, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.New("unsupported private key PEM type " + block.Type)
+	}
+}
+
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.New("unsupported public key PEM type " + block.Type)
+	}
+}
@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// clamdChunkSize is the size of the chunks content is streamed to clamd in
+const clamdChunkSize = 64 << 10
+
+// clamdScanner scans content with a ClamAV daemon over the INSTREAM command.
+// Each scan uses its own connection, so it is safe for concurrent use.
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func NewClamdScanner(cfg config.ScanConfig) domain.Scanner {
+	return &clamdScanner{
+		network: cfg.Network,
+		address: cfg.Address,
+		timeout: cfg.Timeout,
+	}
+}
+
+// Scan streams r to clamd as length-prefixed chunks, terminated by an empty
+// chunk, and parses the single-line verdict: "stream: OK",
+// "stream: <signature> FOUND" or "<message> ERROR".
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (*domain.ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// Unblock reads and writes at once if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// clamd stops reading and replies early when the stream exceeds its
+	// StreamMaxLength, so a failed write may still be followed by a verdict
+	writeErr := s.stream(conn, r)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if reply == "" {
+		if writeErr != nil {
+			return nil, fmt.Errorf("failed to stream to clamd: %w", writeErr)
+		}
+		return nil, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+
+	return parseClamdReply(reply)
+}
+
+func (s *clamdScanner) stream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, writeErr := conn.Write(buf[:4+n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func parseClamdReply(reply string) (*domain.ScanResult, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	verdict := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case verdict == "OK":
+		return &domain.ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &domain.ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("clamd scan failed: %s", reply)
+	}
+}
@@ -0,0 +1,198 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// fakeClamd answers INSTREAM requests on a localhost listener. It records the
+// chunks it received and replies with reply once the stream has ended, or as soon
+// as more than replyAfter bytes arrived when replyAfter is positive, the way clamd
+// cuts off streams over its StreamMaxLength.
+type fakeClamd struct {
+	reply      string
+	replyAfter int
+
+	command    chan string
+	chunks     chan []int
+	terminated chan bool
+}
+
+func newFakeClamd(t *testing.T, reply string, replyAfter int) (*fakeClamd, domain.Scanner) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakeClamd{
+		reply:      reply,
+		replyAfter: replyAfter,
+		command:    make(chan string, 1),
+		chunks:     make(chan []int, 1),
+		terminated: make(chan bool, 1),
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fake.serve(conn)
+	}()
+
+	scanner := NewClamdScanner(config.ScanConfig{
+		Network: "tcp",
+		Address: listener.Addr().String(),
+		Timeout: 5 * time.Second,
+	})
+	return fake, scanner
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	command, _ := r.ReadString(0)
+	f.command <- command
+
+	var chunks []int
+	received := 0
+	terminated := false
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			break
+		}
+		if size == 0 {
+			terminated = true
+			break
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			break
+		}
+		chunks = append(chunks, int(size))
+		received += int(size)
+		if f.replyAfter > 0 && received > f.replyAfter {
+			break
+		}
+	}
+
+	f.chunks <- chunks
+	f.terminated <- terminated
+	io.WriteString(conn, f.reply+"\x00")
+}
+
+func TestClamdScannerStreamsChunks(t *testing.T) {
+	fake, scanner := newFakeClamd(t, "stream: OK", 0)
+	content := bytes.Repeat([]byte("x"), 2*clamdChunkSize+100)
+
+	result, err := scanner.Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if result.Infected {
+		t.Errorf("Scan = %+v, want clean", result)
+	}
+
+	if command := <-fake.command; command != "zINSTREAM\x00" {
+		t.Errorf("command = %q, want zINSTREAM", command)
+	}
+	chunks := <-fake.chunks
+	want := []int{clamdChunkSize, clamdChunkSize, 100}
+	if len(chunks) != len(want) || chunks[0] != want[0] || chunks[1] != want[1] || chunks[2] != want[2] {
+		t.Errorf("chunks = %v, want %v", chunks, want)
+	}
+	if !<-fake.terminated {
+		t.Error("stream was not terminated with a zero-length chunk")
+	}
+}
+
+func TestClamdScannerReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		infected  bool
+		signature string
+		wantErr   string
+	}{
+		{name: "clean", reply: "stream: OK"},
+		{name: "infected", reply: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{name: "error", reply: "INSTREAM size limit exceeded. ERROR", wantErr: "size limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, scanner := newFakeClamd(t, tt.reply, 0)
+
+			result, err := scanner.Scan(context.Background(), strings.NewReader("content"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Scan error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if result.Infected != tt.infected || result.Signature != tt.signature {
+				t.Errorf("Scan = %+v, want infected=%v signature=%q", result, tt.infected, tt.signature)
+			}
+		})
+	}
+}
+
+// clamd replies and closes the connection once a stream exceeds StreamMaxLength,
+// while the client may still be writing; the verdict must still be read
+func TestClamdScannerReplyBeforeStreamEnds(t *testing.T) {
+	fake, scanner := newFakeClamd(t, "INSTREAM size limit exceeded. ERROR", clamdChunkSize)
+	content := bytes.Repeat([]byte("x"), 64*clamdChunkSize)
+
+	_, err := scanner.Scan(context.Background(), bytes.NewReader(content))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan error = %v, want the clamd reply", err)
+	}
+	if <-fake.terminated {
+		t.Error("fake clamd should have cut the stream off")
+	}
+}
+
+func TestClamdScannerHonoursContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Accept and read, but never reply
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	scanner := NewClamdScanner(config.ScanConfig{Network: "tcp", Address: listener.Addr().String(), Timeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := scanner.Scan(ctx, strings.NewReader("content")); err == nil {
+		t.Fatal("Scan succeeded without a reply")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Scan took %v after the context expired", elapsed)
+	}
+}
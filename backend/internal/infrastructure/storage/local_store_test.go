@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+func TestLocalStorePathRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	store := &localStore{root: root}
+
+	rejected := []string{
+		"",
+		"..",
+		"../outside",
+		"../../etc/passwd",
+		"a/../../outside",
+		"sha256/../../outside",
+		"/etc/passwd",
+	}
+	for _, key := range rejected {
+		if path, err := store.path(key); err == nil {
+			t.Errorf("path(%q) = %q, want an error", key, path)
+		}
+	}
+
+	accepted := map[string]string{
+		"sha256/ab/cd/hash":    filepath.Join(root, "sha256", "ab", "cd", "hash"),
+		"a/../b":               filepath.Join(root, "b"),
+		"./file":               filepath.Join(root, "file"),
+		"..hidden":             filepath.Join(root, "..hidden"),
+		"dir/..hidden/file.jp": filepath.Join(root, "dir", "..hidden", "file.jp"),
+	}
+	for key, want := range accepted {
+		path, err := store.path(key)
+		if err != nil {
+			t.Errorf("path(%q): %v", key, err)
+			continue
+		}
+		if path != want {
+			t.Errorf("path(%q) = %q, want %q", key, path, want)
+		}
+	}
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	ctx := context.Background()
+	key := "sha256/ab/cd/hash"
+
+	if _, err := store.Put(ctx, "../outside", strings.NewReader("x")); err == nil {
+		t.Error("Put outside the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "outside")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file written outside the root: %v", err)
+	}
+
+	if size, err := store.Put(ctx, key, strings.NewReader("content")); err != nil || size != 7 {
+		t.Fatalf("Put = %d, %v", size, err)
+	}
+
+	content, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(content)
+	content.Close()
+	if err != nil || string(data) != "content" {
+		t.Fatalf("Get content = %q, %v", data, err)
+	}
+
+	var walked []string
+	err = store.Walk(ctx, func(info *domain.BlobInfo) error {
+		walked = append(walked, info.Key)
+		return nil
+	})
+	if err != nil || len(walked) != 1 || walked[0] != key {
+		t.Errorf("Walk = %q, %v", walked, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, domain.ErrBlobNotFound) {
+		t.Errorf("Stat after Delete = %v, want ErrBlobNotFound", err)
+	}
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+const (
+	fakeS3Bucket    = "uploads"
+	fakeS3Region    = "eu-west-1"
+	fakeS3AccessKey = "test-access-key"
+	fakeS3SecretKey = "test-secret-key"
+)
+
+// fakeS3 is an in-memory, path-style S3 stand-in. It checks every request's
+// SigV4 signature and payload hash and serves ListObjectsV2 in pages of pageSize.
+type fakeS3 struct {
+	t        *testing.T
+	pageSize int
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modTimes map[string]time.Time
+	ranges   []string
+}
+
+func newFakeS3(t *testing.T, pageSize int) (*fakeS3, domain.BlobStore) {
+	t.Helper()
+
+	fake := &fakeS3{t: t, pageSize: pageSize, objects: map[string][]byte{}, modTimes: map[string]time.Time{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Store(config.S3Config{
+		Endpoint:        server.URL,
+		Region:          fakeS3Region,
+		Bucket:          fakeS3Bucket,
+		AccessKeyID:     fakeS3AccessKey,
+		SecretAccessKey: fakeS3SecretKey,
+		UsePathStyle:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return fake, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := f.checkSignature(r, body); msg != "" {
+		f.t.Errorf("%s %s: %s", r.Method, r.URL, msg)
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/"+fakeS3Bucket {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+		f.modTimes[key] = time.Now()
+	case http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", f.modTimes[key].UTC().Format(http.TimeFormat))
+	case http.MethodGet:
+		content, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		rangeHeader := r.Header.Get("Range")
+		f.ranges = append(f.ranges, rangeHeader)
+		if rangeHeader == "" {
+			w.Write(content)
+			return
+		}
+		offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+		if err != nil || offset >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[offset:])
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature re-signs the request as received and compares the result with
+// the Authorization header the client sent
+func (f *fakeS3) checkSignature(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(body)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != hex.EncodeToString(sum[:]) {
+		return "x-amz-content-sha256 does not match the body"
+	}
+
+	signedAt, err := time.Parse(sigV4TimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "missing or invalid x-amz-date"
+	}
+
+	received := r.Header.Get("Authorization")
+	if !strings.HasPrefix(received, "AWS4-HMAC-SHA256 Credential="+fakeS3AccessKey+"/"+
+		signedAt.Format(sigV4DateFormat)+"/"+fakeS3Region+"/s3/aws4_request, SignedHeaders=") {
+		return "unexpected credential scope in " + received
+	}
+
+	check, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return err.Error()
+	}
+	for name, values := range r.Header {
+		if name != "Authorization" {
+			check.Header[name] = values
+		}
+	}
+	signV4(check, fakeS3AccessKey, fakeS3SecretKey, fakeS3Region, payloadHash, signedAt)
+	if expected := check.Header.Get("Authorization"); received != expected {
+		return "signature mismatch: got " + received + ", want " + expected
+	}
+	return ""
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// The continuation token is simply the last key of the previous page
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token) + 1
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{IsTruncated: end < len(keys)}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			Size:         len(f.objects[key]),
+			LastModified: f.modTimes[key].UTC().Format(time.RFC3339),
+		})
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = keys[end-1]
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3StorePutGetStatDelete(t *testing.T) {
+	fake, store := newFakeS3(t, 1000)
+	ctx := context.Background()
+	key := "sha256/ab/cd/some file+name.jpg"
+	content := []byte("0123456789abcdefghij")
+
+	size, err := store.Put(ctx, key, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("Put size = %d, want %d", size, len(content))
+	}
+
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	object, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer object.Close()
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(object, head); err != nil || string(head) != "0123" {
+		t.Fatalf("first read = %q, %v", head, err)
+	}
+	if pos, err := object.Seek(10, io.SeekStart); err != nil || pos != 10 {
+		t.Fatalf("Seek(10, start) = %d, %v", pos, err)
+	}
+	if rest, err := io.ReadAll(object); err != nil || string(rest) != "abcdefghij" {
+		t.Fatalf("read after seek = %q, %v", rest, err)
+	}
+	if pos, err := object.Seek(-3, io.SeekEnd); err != nil || pos != 17 {
+		t.Fatalf("Seek(-3, end) = %d, %v", pos, err)
+	}
+	if tail, err := io.ReadAll(object); err != nil || string(tail) != "hij" {
+		t.Fatalf("read after seek from end = %q, %v", tail, err)
+	}
+
+	fake.mu.Lock()
+	ranges := append([]string(nil), fake.ranges...)
+	fake.mu.Unlock()
+	wantRanges := []string{"", "bytes=10-", "bytes=17-"}
+	if strings.Join(ranges, ",") != strings.Join(wantRanges, ",") {
+		t.Errorf("ranged GETs = %q, want %q", ranges, wantRanges)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, domain.ErrBlobNotFound) {
+		t.Errorf("Stat after Delete = %v, want ErrBlobNotFound", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, domain.ErrBlobNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete(ctx, key); !errors.Is(err, domain.ErrBlobNotFound) {
+		t.Errorf("second Delete = %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestS3StoreWalkPages(t *testing.T) {
+	_, store := newFakeS3(t, 2)
+	ctx := context.Background()
+
+	want := []string{"a", "b/1", "b/2", "c d", "e"}
+	for _, key := range want {
+		if _, err := store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+	}
+
+	var got []string
+	err := store.Walk(ctx, func(info *domain.BlobInfo) error {
+		got = append(got, info.Key)
+		if info.Size != int64(len(info.Key)) {
+			t.Errorf("size of %q = %d", info.Key, info.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Walk = %q, want %q", got, want)
+	}
+
+	// An error from fn stops the walk
+	stop := errors.New("stop")
+	calls := 0
+	err = store.Walk(ctx, func(*domain.BlobInfo) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Walk with failing fn = %v after %d calls", err, calls)
+	}
+}
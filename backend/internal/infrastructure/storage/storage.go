@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// NewBlobStore creates the blob store selected by upload.storage
+func NewBlobStore(cfg config.UploadConfig) (domain.BlobStore, error) {
+	switch cfg.Storage {
+	case "", BackendLocal:
+		return NewLocalStore(cfg.TempDir)
+	case BackendS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
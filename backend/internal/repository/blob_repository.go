@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type blobRepository struct {
+	db dbtx
+}
+
+func NewBlobRepository(db *sql.DB) domain.BlobRepository {
+	return &blobRepository{db: db}
+}
+
+// Acquire upserts the blob; xmax is 0 only for rows this statement inserted
+func (r *blobRepository) Acquire(ctx context.Context, blob *domain.Blob) (bool, error) {
+	var created bool
+	query := `INSERT INTO blobs (hash, file_path, size, ref_count, created_at) VALUES ($1, $2, $3, 1, $4)
+              ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1
+              RETURNING file_path, ref_count, created_at, (xmax = 0)`
+	err := r.db.QueryRowContext(ctx, query, blob.Hash, blob.Key, blob.Size, blob.CreatedAt).
+		Scan(&blob.Key, &blob.RefCount, &blob.CreatedAt, &created)
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+func (r *blobRepository) Release(ctx context.Context, hash string) (*domain.Blob, error) {
+	blob := &domain.Blob{}
+	query := `UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = $1
+              RETURNING hash, file_path, size, ref_count, created_at`
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&blob.Hash, &blob.Key, &blob.Size,
+		&blob.RefCount, &blob.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (r *blobRepository) DeleteUnreferenced(ctx context.Context, hash string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM blobs WHERE hash = $1 AND ref_count <= 0`, hash)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *blobRepository) ListAfterHash(ctx context.Context, afterHash string, limit int) ([]*domain.Blob, error) {
+	query := `SELECT hash, file_path, size, ref_count, created_at FROM blobs
+              WHERE hash > $1 ORDER BY hash LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, afterHash, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []*domain.Blob{}
+	for rows.Next() {
+		blob := &domain.Blob{}
+		if err := rows.Scan(&blob.Hash, &blob.Key, &blob.Size, &blob.RefCount, &blob.CreatedAt); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
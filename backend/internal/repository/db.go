@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is what repositories run their statements on: the *sql.DB itself, or a
+// *sql.Tx when they take part in a unit of work
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
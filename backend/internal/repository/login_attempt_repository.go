@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type loginAttemptRepository struct {
+	db dbtx
+}
+
+func NewLoginAttemptRepository(db *sql.DB) domain.LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (r *loginAttemptRepository) Get(ctx context.Context, scope, key string) (*domain.LoginAttempt, error) {
+	attempt := &domain.LoginAttempt{}
+	query := `SELECT id, scope, key, failures, last_failure_at, locked_until 
+              FROM login_attempts WHERE scope = $1 AND key = $2`
+	err := r.db.QueryRowContext(ctx, query, scope, key).Scan(&attempt.ID, &attempt.Scope, &attempt.Key,
+		&attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, scope, key string, at time.Time, windowStart time.Time) (*domain.LoginAttempt, error) {
+	attempt := &domain.LoginAttempt{}
+	query := `INSERT INTO login_attempts (scope, key, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+              ON CONFLICT (scope, key) DO UPDATE SET
+                  failures = CASE WHEN login_attempts.last_failure_at < $4 THEN 1 ELSE login_attempts.failures + 1 END,
+                  last_failure_at = EXCLUDED.last_failure_at
+              RETURNING id, scope, key, failures, last_failure_at, locked_until`
+	err := r.db.QueryRowContext(ctx, query, scope, key, at, windowStart).Scan(&attempt.ID, &attempt.Scope, &attempt.Key,
+		&attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, scope, key string, until time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $1 WHERE scope = $2 AND key = $3`, until, scope, key)
+	return err
+}
+
+func (r *loginAttemptRepository) Reset(ctx context.Context, scope, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE scope = $1 AND key = $2`, scope, key)
+	return err
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type quotaRepository struct {
+	db dbtx
+}
+
+func NewQuotaRepository(db *sql.DB) domain.QuotaRepository {
+	return &quotaRepository{db: db}
+}
+
+func (r *quotaRepository) GetOverride(ctx context.Context, userID int) (*domain.QuotaOverride, error) {
+	override := &domain.QuotaOverride{}
+	query := `SELECT user_id, max_bytes, max_files, updated_at FROM user_quotas WHERE user_id = $1`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&override.UserID, &override.MaxBytes,
+		&override.MaxFiles, &override.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return override, nil
+}
+
+func (r *quotaRepository) SetOverride(ctx context.Context, override *domain.QuotaOverride) error {
+	query := `INSERT INTO user_quotas (user_id, max_bytes, max_files, updated_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (user_id) DO UPDATE SET max_bytes = EXCLUDED.max_bytes,
+                  max_files = EXCLUDED.max_files, updated_at = EXCLUDED.updated_at`
+	_, err := r.db.ExecContext(ctx, query, override.UserID, override.MaxBytes, override.MaxFiles, override.UpdatedAt)
+	return err
+}
+
+func (r *quotaRepository) DeleteOverride(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM user_quotas WHERE user_id = $1`, userID)
+	return err
+}
+
+func (r *quotaRepository) GetUsage(ctx context.Context, userID int) (int64, int, error) {
+	if err := r.ensureUsage(ctx, userID); err != nil {
+		return 0, 0, err
+	}
+
+	var bytes int64
+	var files int
+	query := `SELECT used_bytes, used_files FROM user_storage_usage WHERE user_id = $1`
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&bytes, &files); err != nil {
+		return 0, 0, err
+	}
+	return bytes, files, nil
+}
+
+func (r *quotaRepository) Reserve(ctx context.Context, userID int, size int64, maxBytes int64, maxFiles int) error {
+	if err := r.ensureUsage(ctx, userID); err != nil {
+		return err
+	}
+
+	query := `UPDATE user_storage_usage SET used_bytes = used_bytes + $2, used_files = used_files + 1, updated_at = $5
+              WHERE user_id = $1 AND used_bytes + $2 <= $3 AND used_files + 1 <= $4`
+	result, err := r.db.ExecContext(ctx, query, userID, size, maxBytes, maxFiles, time.Now())
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *quotaRepository) Release(ctx context.Context, userID int, size int64) error {
+	query := `UPDATE user_storage_usage SET used_bytes = GREATEST(used_bytes - $2, 0),
+                  used_files = GREATEST(used_files - 1, 0), updated_at = $3
+              WHERE user_id = $1`
+	_, err := r.db.ExecContext(ctx, query, userID, size, time.Now())
+	return err
+}
+
+// ensureUsage creates the user's usage row on first use, counting the uploads
+// that already exist
+func (r *quotaRepository) ensureUsage(ctx context.Context, userID int) error {
+	query := `INSERT INTO user_storage_usage (user_id, used_bytes, used_files, updated_at)
+              SELECT $1, COALESCE(SUM(size), 0), COUNT(*), $2 FROM file_uploads WHERE user_id = $1
+              ON CONFLICT (user_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, userID, time.Now())
+	return err
+}
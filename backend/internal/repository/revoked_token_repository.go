@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type revokedTokenRepository struct {
+	db dbtx
+}
+
+func NewRevokedTokenRepository(db *sql.DB) domain.RevokedTokenRepository {
+	return &revokedTokenRepository{db: db}
+}
+
+func (r *revokedTokenRepository) Create(ctx context.Context, token *domain.RevokedToken) error {
+	query := `INSERT INTO revoked_tokens (jti, revoked_at, expires_at) VALUES ($1, $2, $3)
+              ON CONFLICT (jti) DO UPDATE SET revoked_at = revoked_tokens.revoked_at RETURNING id`
+	return r.db.QueryRowContext(ctx, query, token.TokenID, token.RevokedAt, token.ExpiresAt).Scan(&token.ID)
+}
+
+func (r *revokedTokenRepository) Exists(ctx context.Context, tokenID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	if err := r.db.QueryRowContext(ctx, query, tokenID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *revokedTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+	"github.com/xarcher/backend/internal/infrastructure/database"
+)
+
+// Migrations run on every start, so they must apply again to a migrated
+// database, which no longer has the token column the early ones created
+func TestMigrationsRerunWithoutTokenColumn(t *testing.T) {
+	db := testDB(t)
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("rerun migrations: %v", err)
+	}
+
+	var columns int
+	err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+                        WHERE table_name = 'revoked_tokens' AND column_name = 'token'`).Scan(&columns)
+	if err != nil {
+		t.Fatalf("look up columns: %v", err)
+	}
+	if columns != 0 {
+		t.Error("revoked_tokens still has the token column")
+	}
+}
+
+func TestRevokedTokenRepository(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	tokens := &revokedTokenRepository{db: tx}
+
+	now := time.Now().UTC()
+	for _, token := range []*domain.RevokedToken{
+		{TokenID: "revoked-expired", RevokedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{TokenID: "revoked-live", RevokedAt: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := tokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create %s: %v", token.TokenID, err)
+		}
+	}
+	// Revoking again is not an error
+	if err := tokens.Create(ctx, &domain.RevokedToken{TokenID: "revoked-live", RevokedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Create again: %v", err)
+	}
+
+	deleted, err := tokens.DeleteExpired(ctx, now)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpired removed %d revocations, want 1", deleted)
+	}
+
+	for tokenID, want := range map[string]bool{"revoked-expired": false, "revoked-live": true, "never-revoked": false} {
+		if exists, err := tokens.Exists(ctx, tokenID); err != nil || exists != want {
+			t.Errorf("Exists(%q) = %v, %v, want %v", tokenID, exists, err, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type shareRepository struct {
+	db dbtx
+}
+
+func NewShareRepository(db *sql.DB) domain.UploadShareRepository {
+	return &shareRepository{db: db}
+}
+
+const shareColumns = `id, upload_id, user_id, created_by, expires_at, max_downloads, download_count,
+                       revoked_at, created_at`
+
+func scanShare(row rowScanner) (*domain.UploadShare, error) {
+	share := &domain.UploadShare{}
+	var createdBy, maxDownloads sql.NullInt64
+	err := row.Scan(&share.ID, &share.UploadID, &share.UserID, &createdBy, &share.ExpiresAt, &maxDownloads,
+		&share.DownloadCount, &share.RevokedAt, &share.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		value := int(createdBy.Int64)
+		share.CreatedBy = &value
+	}
+	if maxDownloads.Valid {
+		value := int(maxDownloads.Int64)
+		share.MaxDownloads = &value
+	}
+	return share, nil
+}
+
+func (r *shareRepository) Create(ctx context.Context, share *domain.UploadShare) error {
+	query := `INSERT INTO upload_shares (upload_id, user_id, created_by, expires_at, max_downloads, download_count, created_at)
+              VALUES ($1, $2, $3, $4, $5, 0, $6) RETURNING id`
+	return r.db.QueryRowContext(ctx, query, share.UploadID, share.UserID, share.CreatedBy, share.ExpiresAt,
+		share.MaxDownloads, share.CreatedAt).Scan(&share.ID)
+}
+
+func (r *shareRepository) GetByID(ctx context.Context, id int) (*domain.UploadShare, error) {
+	query := `SELECT ` + shareColumns + ` FROM upload_shares WHERE id = $1`
+	return scanShare(r.db.QueryRowContext(ctx, query, id))
+}
+
+func (r *shareRepository) ListByUploadID(ctx context.Context, uploadID int) ([]*domain.UploadShare, error) {
+	query := `SELECT ` + shareColumns + ` FROM upload_shares WHERE upload_id = $1 ORDER BY id DESC`
+	rows, err := r.db.QueryContext(ctx, query, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []*domain.UploadShare{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (r *shareRepository) Revoke(ctx context.Context, id int, revokedAt time.Time) error {
+	query := `UPDATE upload_shares SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, id, revokedAt)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+// RecordDownload checks and counts in one statement, so concurrent downloads
+// cannot exceed max_downloads
+func (r *shareRepository) RecordDownload(ctx context.Context, id int, now time.Time) (*domain.UploadShare, error) {
+	query := `UPDATE upload_shares SET download_count = download_count + 1
+              WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2
+                AND (max_downloads IS NULL OR download_count < max_downloads)
+              RETURNING ` + shareColumns
+	return scanShare(r.db.QueryRowContext(ctx, query, id, now))
+}
@@ -1,36 +1,183 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/xarcher/backend/internal/domain"
 )
 
 type uploadRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUploadRepository(db *sql.DB) domain.UploadRepository {
 	return &uploadRepository{db: db}
 }
 
-func (r *uploadRepository) Create(upload *domain.FileUpload) error {
-	query := `INSERT INTO file_uploads (filename, content_type, size, file_path, user_agent, remote_addr, user_id, created_at) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
-	return r.db.QueryRow(query, upload.Filename, upload.ContentType, upload.Size,
-		upload.FilePath, upload.UserAgent, upload.RemoteAddr,
-		upload.UserID, upload.CreatedAt).Scan(&upload.ID)
-}
+const uploadColumns = `id, filename, content_type, size, file_path, COALESCE(checksum_sha256, ''),
+                       COALESCE(stored_sha256, checksum_sha256, ''),
+                       COALESCE(width, 0), COALESCE(height, 0), COALESCE(camera_make, ''),
+                       COALESCE(camera_model, ''), taken_at, scan_status, COALESCE(scan_signature, ''),
+                       scanned_at, user_agent, remote_addr, user_id, created_at`
 
-func (r *uploadRepository) GetByID(id int) (*domain.FileUpload, error) {
+func scanUpload(row rowScanner) (*domain.FileUpload, error) {
 	upload := &domain.FileUpload{}
-	query := `SELECT id, filename, content_type, size, file_path, user_agent, remote_addr, user_id, created_at 
-              FROM file_uploads WHERE id = $1`
-	err := r.db.QueryRow(query, id).Scan(&upload.ID, &upload.Filename, &upload.ContentType,
-		&upload.Size, &upload.FilePath, &upload.UserAgent,
+	err := row.Scan(&upload.ID, &upload.Filename, &upload.ContentType,
+		&upload.Size, &upload.FilePath, &upload.ChecksumSHA256, &upload.StoredSHA256, &upload.Width, &upload.Height,
+		&upload.CameraMake, &upload.CameraModel, &upload.TakenAt, &upload.ScanStatus,
+		&upload.ScanSignature, &upload.ScannedAt, &upload.UserAgent,
 		&upload.RemoteAddr, &upload.UserID, &upload.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return upload, nil
 }
+
+func scanUploads(rows *sql.Rows) ([]*domain.FileUpload, error) {
+	defer rows.Close()
+
+	uploads := []*domain.FileUpload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (r *uploadRepository) Create(ctx context.Context, upload *domain.FileUpload) error {
+	query := `INSERT INTO file_uploads (filename, content_type, size, file_path, checksum_sha256, stored_sha256, width, height,
+                                       camera_make, camera_model, taken_at, scan_status, user_agent, remote_addr, user_id, created_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12, $13, $14, $15, $16) RETURNING id`
+	return r.db.QueryRowContext(ctx, query, upload.Filename, upload.ContentType, upload.Size,
+		upload.FilePath, upload.ChecksumSHA256, upload.StoredSHA256, upload.Width, upload.Height,
+		upload.CameraMake, upload.CameraModel, upload.TakenAt, upload.ScanStatus, upload.UserAgent, upload.RemoteAddr,
+		upload.UserID, upload.CreatedAt).Scan(&upload.ID)
+}
+
+func (r *uploadRepository) GetByID(ctx context.Context, id int) (*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads WHERE id = $1`
+	return scanUpload(r.db.QueryRowContext(ctx, query, id))
+}
+
+// Find returns one page of a user's uploads using keyset pagination on (sort column, id)
+func (r *uploadRepository) Find(ctx context.Context, filter domain.UploadFilter) ([]*domain.FileUpload, error) {
+	sortColumn := "created_at"
+	if filter.SortBy == domain.UploadSortSize {
+		sortColumn = "size"
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{filter.UserID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.ContentType != "" {
+		addCondition("content_type = $%d", filter.ContentType)
+	}
+	if filter.CreatedFrom != nil {
+		addCondition("created_at >= $%d", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		addCondition("created_at < $%d", *filter.CreatedTo)
+	}
+	if filter.After != nil {
+		var afterValue interface{} = filter.After.CreatedAt
+		if sortColumn == "size" {
+			afterValue = filter.After.Size
+		}
+		args = append(args, afterValue, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)",
+			sortColumn, comparison, len(args)-1, len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`SELECT %s FROM file_uploads WHERE %s ORDER BY %s %s, id %s LIMIT $%d`,
+		uploadColumns, strings.Join(conditions, " AND "), sortColumn, direction, direction, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) ListByUserID(ctx context.Context, userID int) ([]*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads WHERE user_id = $1 ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) List(ctx context.Context, limit, offset int) ([]*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads ORDER BY id DESC LIMIT $1 OFFSET $2`
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) ListByScanStatus(ctx context.Context, status string, afterID int, limit int) ([]*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads WHERE scan_status = $1 AND id > $2 ORDER BY id LIMIT $3`
+	rows, err := r.db.QueryContext(ctx, query, status, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) ListMissingVariants(ctx context.Context, names []string, afterID int, limit int) ([]*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads
+              WHERE scan_status = $1 AND id > $2
+                AND (SELECT COUNT(*) FROM file_upload_variants v
+                     WHERE v.upload_id = file_uploads.id AND v.name = ANY($3)) < $4
+              ORDER BY id LIMIT $5`
+	rows, err := r.db.QueryContext(ctx, query, domain.ScanStatusClean, afterID, pq.Array(names), len(names), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) SetScanResult(ctx context.Context, id int, status string, signature string, scannedAt time.Time) error {
+	query := `UPDATE file_uploads SET scan_status = $2, scan_signature = NULLIF($3, ''), scanned_at = $4 WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query, id, status, signature, scannedAt)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *uploadRepository) ListAfterID(ctx context.Context, afterID int, limit int) ([]*domain.FileUpload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM file_uploads WHERE id > $1 ORDER BY id LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanUploads(rows)
+}
+
+func (r *uploadRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM file_uploads WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
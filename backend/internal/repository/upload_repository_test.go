@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+func TestUploadRepositoryCreate(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	users := &userRepository{db: tx}
+	uploads := &uploadRepository{db: tx}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	user := &domain.User{Username: "upload-repo-test", Password: "x", Role: domain.RoleUser, CreatedAt: now, UpdatedAt: now}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	takenAt := now.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		upload *domain.FileUpload
+	}{
+		{
+			name: "without camera metadata",
+			upload: &domain.FileUpload{
+				Filename: "plain.png", ContentType: "image/png", Size: 10, FilePath: "sha256/aa/bb/plain",
+				ChecksumSHA256: "aabb", StoredSHA256: "aabb", Width: 2, Height: 3, ScanStatus: domain.ScanStatusClean,
+				UserAgent: "test", RemoteAddr: "127.0.0.1", UserID: user.ID, CreatedAt: now,
+			},
+		},
+		{
+			name: "with camera metadata",
+			upload: &domain.FileUpload{
+				Filename: "photo.jpg", ContentType: "image/jpeg", Size: 20, FilePath: "sha256/cc/dd/photo",
+				ChecksumSHA256: "ccdd", StoredSHA256: "eeff", Width: 4, Height: 5, CameraMake: "Canon", CameraModel: "EOS R5",
+				TakenAt: &takenAt, ScanStatus: domain.ScanStatusPending,
+				UserAgent: "test", RemoteAddr: "127.0.0.1", UserID: user.ID, CreatedAt: now,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := uploads.Create(ctx, tt.upload); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if tt.upload.ID == 0 {
+				t.Fatal("Create did not set the ID")
+			}
+
+			got, err := uploads.GetByID(ctx, tt.upload.ID)
+			if err != nil {
+				t.Fatalf("GetByID: %v", err)
+			}
+
+			want := tt.upload
+			if got.Filename != want.Filename || got.ContentType != want.ContentType || got.Size != want.Size ||
+				got.FilePath != want.FilePath || got.ChecksumSHA256 != want.ChecksumSHA256 || got.StoredSHA256 != want.StoredSHA256 ||
+				got.Width != want.Width || got.Height != want.Height || got.ScanStatus != want.ScanStatus ||
+				got.UserAgent != want.UserAgent || got.RemoteAddr != want.RemoteAddr || got.UserID != want.UserID {
+				t.Errorf("GetByID = %+v, want %+v", got, want)
+			}
+			if got.CameraMake != want.CameraMake || got.CameraModel != want.CameraModel {
+				t.Errorf("camera = %q %q, want %q %q", got.CameraMake, got.CameraModel, want.CameraMake, want.CameraModel)
+			}
+			if (got.TakenAt == nil) != (want.TakenAt == nil) ||
+				(got.TakenAt != nil && !got.TakenAt.Equal(*want.TakenAt)) {
+				t.Errorf("TakenAt = %v, want %v", got.TakenAt, want.TakenAt)
+			}
+		})
+	}
+}
+
+func TestUploadRepositoryListMissingVariants(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	users := &userRepository{db: tx}
+	uploads := &uploadRepository{db: tx}
+	variants := &variantRepository{db: tx}
+
+	now := time.Now().UTC()
+	user := &domain.User{Username: "missing-variants-test", Password: "x", Role: domain.RoleUser, CreatedAt: now, UpdatedAt: now}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	// complete has every variant, partial one of two, none and pending none
+	ids := map[string]int{}
+	for _, name := range []string{"complete", "partial", "none", "pending"} {
+		status := domain.ScanStatusClean
+		if name == "pending" {
+			status = domain.ScanStatusPending
+		}
+		upload := &domain.FileUpload{Filename: name + ".png", ContentType: "image/png", Size: 1,
+			FilePath: "sha256/" + name, ScanStatus: status, UserID: user.ID, CreatedAt: now}
+		if err := uploads.Create(ctx, upload); err != nil {
+			t.Fatalf("create upload %s: %v", name, err)
+		}
+		ids[name] = upload.ID
+	}
+	for _, variant := range []struct {
+		upload string
+		name   string
+	}{{"complete", "thumb"}, {"complete", "medium"}, {"partial", "thumb"}, {"none", "retired"}} {
+		err := variants.Upsert(ctx, &domain.FileUploadVariant{UploadID: ids[variant.upload], Name: variant.name,
+			ContentType: "image/jpeg", FilePath: "sha256/" + variant.upload + "." + variant.name, CreatedAt: now})
+		if err != nil {
+			t.Fatalf("create variant: %v", err)
+		}
+	}
+
+	names := []string{"thumb", "medium"}
+	got, err := uploads.ListMissingVariants(ctx, names, ids["complete"]-1, 10)
+	if err != nil {
+		t.Fatalf("ListMissingVariants: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != ids["partial"] || got[1].ID != ids["none"] {
+		t.Fatalf("ListMissingVariants = %v, want partial and none", uploadIDs(got))
+	}
+
+	got, err = uploads.ListMissingVariants(ctx, names, ids["partial"], 10)
+	if err != nil {
+		t.Fatalf("ListMissingVariants after partial: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != ids["none"] {
+		t.Fatalf("ListMissingVariants after partial = %v, want none", uploadIDs(got))
+	}
+}
+
+func uploadIDs(uploads []*domain.FileUpload) []int {
+	ids := make([]int, 0, len(uploads))
+	for _, upload := range uploads {
+		ids = append(ids, upload.ID)
+	}
+	return ids
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type uploadSessionRepository struct {
+	db dbtx
+}
+
+func NewUploadSessionRepository(db *sql.DB) domain.UploadSessionRepository {
+	return &uploadSessionRepository{db: db}
+}
+
+const uploadSessionColumns = `id, user_id, filename, content_type, size, upload_offset,
+                              created_at, updated_at, expires_at`
+
+func scanUploadSession(row rowScanner) (*domain.UploadSession, error) {
+	session := &domain.UploadSession{}
+	err := row.Scan(&session.ID, &session.UserID, &session.Filename, &session.ContentType,
+		&session.Size, &session.Offset, &session.CreatedAt, &session.UpdatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *uploadSessionRepository) Create(ctx context.Context, session *domain.UploadSession) error {
+	query := `INSERT INTO upload_sessions (id, user_id, filename, content_type, size, upload_offset, created_at, updated_at, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := r.db.ExecContext(ctx, query, session.ID, session.UserID, session.Filename, session.ContentType,
+		session.Size, session.Offset, session.CreatedAt, session.UpdatedAt, session.ExpiresAt)
+	return err
+}
+
+func (r *uploadSessionRepository) GetByID(ctx context.Context, id string) (*domain.UploadSession, error) {
+	query := `SELECT ` + uploadSessionColumns + ` FROM upload_sessions WHERE id = $1`
+	return scanUploadSession(r.db.QueryRowContext(ctx, query, id))
+}
+
+func (r *uploadSessionRepository) UpdateOffset(ctx context.Context, id string, from int64, to int64, expiresAt time.Time) error {
+	query := `UPDATE upload_sessions SET upload_offset = $3, updated_at = $4, expires_at = $5
+              WHERE id = $1 AND upload_offset = $2`
+	result, err := r.db.ExecContext(ctx, query, id, from, to, time.Now(), expiresAt)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *uploadSessionRepository) Lock(ctx context.Context, id string, userID int, token string, now time.Time,
+	until time.Time) (*domain.UploadSession, error) {
+	query := `UPDATE upload_sessions SET lock_token = $3, locked_until = $5
+              WHERE id = $1 AND user_id = $2 AND (locked_until IS NULL OR locked_until <= $4)
+              RETURNING ` + uploadSessionColumns
+	return scanUploadSession(r.db.QueryRowContext(ctx, query, id, userID, token, now, until))
+}
+
+func (r *uploadSessionRepository) Unlock(ctx context.Context, id string, token string) error {
+	query := `UPDATE upload_sessions SET lock_token = NULL, locked_until = NULL WHERE id = $1 AND lock_token = $2`
+	result, err := r.db.ExecContext(ctx, query, id, token)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *uploadSessionRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM upload_sessions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *uploadSessionRepository) CountOpen(ctx context.Context, userID int, now time.Time) (int, int64, error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM upload_sessions WHERE user_id = $1 AND expires_at > $2`
+	var count int
+	var size int64
+	err := r.db.QueryRowContext(ctx, query, userID, now).Scan(&count, &size)
+	return count, size, err
+}
+
+func (r *uploadSessionRepository) ListExpired(ctx context.Context, before time.Time) ([]*domain.UploadSession, error) {
+	query := `SELECT ` + uploadSessionColumns + ` FROM upload_sessions WHERE expires_at < $1`
+	rows, err := r.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*domain.UploadSession{}
+	for rows.Next() {
+		session, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
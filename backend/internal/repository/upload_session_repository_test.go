@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+func TestUploadSessionRepositoryCountOpen(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	users := &userRepository{db: tx}
+	sessions := &uploadSessionRepository{db: tx}
+
+	now := time.Now().UTC()
+	user := &domain.User{Username: "session-repo-test", Password: "x", Role: domain.RoleUser, CreatedAt: now, UpdatedAt: now}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	for i, session := range []*domain.UploadSession{
+		{ID: "session-open-1", Size: 100, ExpiresAt: now.Add(time.Hour)},
+		{ID: "session-open-2", Size: 50, ExpiresAt: now.Add(time.Hour)},
+		{ID: "session-expired", Size: 1000, ExpiresAt: now.Add(-time.Hour)},
+	} {
+		session.UserID = user.ID
+		session.Filename = "photo.jpg"
+		session.CreatedAt = now
+		session.UpdatedAt = now
+		if err := sessions.Create(ctx, session); err != nil {
+			t.Fatalf("create session %d: %v", i, err)
+		}
+	}
+
+	count, size, err := sessions.CountOpen(ctx, user.ID, now)
+	if err != nil {
+		t.Fatalf("CountOpen: %v", err)
+	}
+	if count != 2 || size != 150 {
+		t.Errorf("CountOpen = %d sessions of %d bytes, want 2 of 150", count, size)
+	}
+
+	if count, size, err := sessions.CountOpen(ctx, user.ID+1, now); err != nil || count != 0 || size != 0 {
+		t.Errorf("CountOpen of a user without sessions = %d, %d, %v", count, size, err)
+	}
+}
@@ -1,40 +1,125 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/xarcher/backend/internal/domain"
 )
 
 type userRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUserRepository(db *sql.DB) domain.UserRepository {
 	return &userRepository{db: db}
 }
 
-func (r *userRepository) Create(user *domain.User) error {
-	query := `INSERT INTO users (username, password, created_at) VALUES ($1, $2, $3) RETURNING id`
-	return r.db.QueryRow(query, user.Username, user.Password, user.CreatedAt).Scan(&user.ID)
+const userColumns = `id, username, password, role, token_version, disabled_at, created_at, updated_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (r *userRepository) GetByUsername(username string) (*domain.User, error) {
+func scanUser(row rowScanner) (*domain.User, error) {
 	user := &domain.User{}
-	query := `SELECT id, username, password, created_at FROM users WHERE username = $1`
-	err := r.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role,
+		&user.TokenVersion, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *userRepository) GetByID(id int) (*domain.User, error) {
-	user := &domain.User{}
-	query := `SELECT id, username, password, created_at FROM users WHERE id = $1`
-	err := r.db.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt)
+func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
+	query := `INSERT INTO users (username, password, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err := r.db.QueryRowContext(ctx, query, user.Username, user.Password, user.Role, user.CreatedAt, user.UpdatedAt).Scan(&user.ID)
+	if isUniqueViolation(err) {
+		return domain.ErrUserExists
+	}
+	return err
+}
+
+func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
+	query := `UPDATE users SET username = $1, updated_at = $2 WHERE id = $3`
+	result, err := r.db.ExecContext(ctx, query, user.Username, user.UpdatedAt, user.ID)
+	if isUniqueViolation(err) {
+		return domain.ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+// Delete removes the user. Uploads and refresh tokens go with it through ON DELETE CASCADE.
+func (r *userRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *userRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	return scanUser(r.db.QueryRowContext(ctx, query, username))
+}
+
+func (r *userRepository) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(r.db.QueryRowContext(ctx, query, id))
+}
+
+func (r *userRepository) List(ctx context.Context, limit, offset int) ([]*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY id LIMIT $1 OFFSET $2`
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	defer rows.Close()
+
+	users := []*domain.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdatePassword stores the new hash and bumps token_version, returning the new version
+func (r *userRepository) UpdatePassword(ctx context.Context, id int, password string) (int, error) {
+	var version int
+	query := `UPDATE users SET password = $1, token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP 
+              WHERE id = $2 RETURNING token_version`
+	err := r.db.QueryRowContext(ctx, query, password, id).Scan(&version)
+	return version, err
+}
+
+func (r *userRepository) IncrementTokenVersion(ctx context.Context, id int) (int, error) {
+	var version int
+	query := `UPDATE users SET token_version = token_version + 1 WHERE id = $1 RETURNING token_version`
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&version)
+	return version, err
+}
+
+func (r *userRepository) SetRole(ctx context.Context, id int, role string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, role, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (r *userRepository) SetDisabledAt(ctx context.Context, id int, disabledAt *time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET disabled_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, disabledAt, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
 }
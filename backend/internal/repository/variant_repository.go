@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type variantRepository struct {
+	db dbtx
+}
+
+func NewVariantRepository(db *sql.DB) domain.FileUploadVariantRepository {
+	return &variantRepository{db: db}
+}
+
+const variantColumns = `id, upload_id, name, content_type, width, height, size, file_path, created_at`
+
+func scanVariant(row rowScanner) (*domain.FileUploadVariant, error) {
+	variant := &domain.FileUploadVariant{}
+	err := row.Scan(&variant.ID, &variant.UploadID, &variant.Name, &variant.ContentType,
+		&variant.Width, &variant.Height, &variant.Size, &variant.FilePath, &variant.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+func scanVariants(rows *sql.Rows) ([]*domain.FileUploadVariant, error) {
+	defer rows.Close()
+
+	variants := []*domain.FileUploadVariant{}
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+func (r *variantRepository) Upsert(ctx context.Context, variant *domain.FileUploadVariant) error {
+	query := `INSERT INTO file_upload_variants (upload_id, name, content_type, width, height, size, file_path, created_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+              ON CONFLICT (upload_id, name) DO UPDATE SET content_type = EXCLUDED.content_type,
+                  width = EXCLUDED.width, height = EXCLUDED.height, size = EXCLUDED.size,
+                  file_path = EXCLUDED.file_path, created_at = EXCLUDED.created_at
+              RETURNING id`
+	return r.db.QueryRowContext(ctx, query, variant.UploadID, variant.Name, variant.ContentType, variant.Width,
+		variant.Height, variant.Size, variant.FilePath, variant.CreatedAt).Scan(&variant.ID)
+}
+
+func (r *variantRepository) GetByUploadAndName(ctx context.Context, uploadID int, name string) (*domain.FileUploadVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM file_upload_variants WHERE upload_id = $1 AND name = $2`
+	return scanVariant(r.db.QueryRowContext(ctx, query, uploadID, name))
+}
+
+func (r *variantRepository) ListByUploadID(ctx context.Context, uploadID int) ([]*domain.FileUploadVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM file_upload_variants WHERE upload_id = $1 ORDER BY name`
+	rows, err := r.db.QueryContext(ctx, query, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVariants(rows)
+}
+
+func (r *variantRepository) ListAfterID(ctx context.Context, afterID int, limit int) ([]*domain.FileUploadVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM file_upload_variants WHERE id > $1 ORDER BY id LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVariants(rows)
+}
+
+func (r *variantRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM file_upload_variants WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
@@ -0,0 +1,201 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type adminUsecase struct {
+	userRepo       domain.UserRepository
+	uploadRepo     domain.UploadRepository
+	quotaRepo      domain.QuotaRepository
+	quotas         *quotaLimits
+	passwordPolicy config.PasswordPolicyConfig
+	timeout        time.Duration
+}
+
+func NewAdminUsecase(userRepo domain.UserRepository, uploadRepo domain.UploadRepository,
+	quotaRepo domain.QuotaRepository, quotaCfg config.QuotaConfig, passwordPolicy config.PasswordPolicyConfig,
+	timeout time.Duration) domain.AdminUsecase {
+	return &adminUsecase{
+		userRepo:       userRepo,
+		uploadRepo:     uploadRepo,
+		quotaRepo:      quotaRepo,
+		quotas:         &quotaLimits{quotaRepo: quotaRepo, quotaCfg: quotaCfg},
+		passwordPolicy: passwordPolicy,
+		timeout:        timeout,
+	}
+}
+
+func (a *adminUsecase) ListUsers(ctx context.Context, limit, offset int) ([]*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return a.userRepo.List(ctx, limit, offset)
+}
+
+// SetUserDisabled disables or re-enables an account. Disabled users are rejected
+// on login, refresh and by the auth middleware, so their tokens stop working at once.
+func (a *adminUsecase) SetUserDisabled(ctx context.Context, userID int, disabled bool) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	if err := a.userRepo.SetDisabledAt(ctx, userID, disabledAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if disabled {
+		log.Printf("Admin disabled user %d", userID)
+	} else {
+		log.Printf("Admin enabled user %d", userID)
+	}
+
+	return a.getUser(ctx, userID)
+}
+
+func (a *adminUsecase) SetUserRole(ctx context.Context, userID int, role string) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if role != domain.RoleUser && role != domain.RoleAdmin {
+		verr := &domain.ValidationError{Message: "validation failed"}
+		verr.Add("role", "must be one of: "+domain.RoleUser+", "+domain.RoleAdmin)
+		return nil, verr
+	}
+
+	if err := a.userRepo.SetRole(ctx, userID, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+
+	log.Printf("Admin set role of user %d to %s", userID, role)
+
+	return a.getUser(ctx, userID)
+}
+
+func (a *adminUsecase) SetUserQuota(ctx context.Context, userID int, req *domain.SetQuotaRequest) (*domain.UserQuota, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	verr := &domain.ValidationError{Message: "validation failed"}
+	if req.MaxBytes != nil && *req.MaxBytes <= 0 {
+		verr.Add("max_bytes", "must be greater than 0")
+	}
+	if req.MaxFiles != nil && *req.MaxFiles <= 0 {
+		verr.Add("max_files", "must be greater than 0")
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	if _, err := a.getUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if req.MaxBytes == nil && req.MaxFiles == nil {
+		err = a.quotaRepo.DeleteOverride(ctx, userID)
+	} else {
+		err = a.quotaRepo.SetOverride(ctx, &domain.QuotaOverride{
+			UserID:    userID,
+			MaxBytes:  req.MaxBytes,
+			MaxFiles:  req.MaxFiles,
+			UpdatedAt: time.Now(),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Admin set quota of user %d", userID)
+
+	return a.quotas.get(ctx, userID)
+}
+
+// CreateAdmin bootstraps an admin, as there is no admin yet to appoint anyone
+// through the API. A taken username is an error rather than a promotion, so a
+// typo cannot hand the admin role to somebody else's account.
+func (a *adminUsecase) CreateAdmin(ctx context.Context, username string, password string) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if err := validateRegistration(&domain.AuthRequest{Username: username, Password: password}, a.passwordPolicy); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &domain.User{
+		Username:  username,
+		Password:  string(hashedPassword),
+		Role:      domain.RoleAdmin,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	// Create reports ErrUserExists for a taken username
+	if err := a.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created admin user %d", user.ID)
+	return user, nil
+}
+
+// PromoteAdmin bootstraps an admin from an existing account, which keeps its password
+func (a *adminUsecase) PromoteAdmin(ctx context.Context, username string) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	user, err := a.userRepo.GetByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != domain.RoleAdmin {
+		if err := a.userRepo.SetRole(ctx, user.ID, domain.RoleAdmin); err != nil {
+			return nil, err
+		}
+		user.Role = domain.RoleAdmin
+		log.Printf("Promoted user %d to admin", user.ID)
+	}
+	return user, nil
+}
+
+func (a *adminUsecase) ListUploads(ctx context.Context, limit, offset int) ([]*domain.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return a.uploadRepo.List(ctx, limit, offset)
+}
+
+func (a *adminUsecase) getUser(ctx context.Context, userID int) (*domain.User, error) {
+	user, err := a.userRepo.GetByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	return user, err
+}
@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// memoryUserRepo keeps users in memory, keyed by username
+type memoryUserRepo struct {
+	domain.UserRepository
+	users map[string]*domain.User
+}
+
+func (r *memoryUserRepo) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	user, ok := r.users[username]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepo) Create(ctx context.Context, user *domain.User) error {
+	if _, ok := r.users[user.Username]; ok {
+		return domain.ErrUserExists
+	}
+	user.ID = len(r.users) + 1
+	r.users[user.Username] = user
+	return nil
+}
+
+func (r *memoryUserRepo) SetRole(ctx context.Context, id int, role string) error {
+	for _, user := range r.users {
+		if user.ID == id {
+			user.Role = role
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func TestAdminUsecaseCreateAdmin(t *testing.T) {
+	users := &memoryUserRepo{users: map[string]*domain.User{
+		"alice": {ID: 1, Username: "alice", Password: "existing hash", Role: domain.RoleUser},
+	}}
+	a := NewAdminUsecase(users, nil, nil, config.QuotaConfig{}, config.PasswordPolicyConfig{MinLength: 12}, time.Second)
+	ctx := context.Background()
+
+	// An existing user is not promoted by CreateAdmin
+	if _, err := a.CreateAdmin(ctx, "alice", "a long enough password"); !errors.Is(err, domain.ErrUserExists) {
+		t.Fatalf("CreateAdmin(alice) = %v, want ErrUserExists", err)
+	}
+	if users.users["alice"].Role != domain.RoleUser || users.users["alice"].Password != "existing hash" {
+		t.Errorf("CreateAdmin(alice) changed the existing user: %+v", users.users["alice"])
+	}
+
+	// but by PromoteAdmin, keeping their password
+	user, err := a.PromoteAdmin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("PromoteAdmin(alice): %v", err)
+	}
+	if user.Role != domain.RoleAdmin || users.users["alice"].Role != domain.RoleAdmin {
+		t.Errorf("PromoteAdmin(alice) = %+v, want alice promoted", user)
+	}
+	if users.users["alice"].Password != "existing hash" {
+		t.Error("promoting alice changed her password")
+	}
+	if _, err := a.PromoteAdmin(ctx, "nobody"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("PromoteAdmin(nobody) = %v, want ErrNotFound", err)
+	}
+
+	// A new user must satisfy the password policy
+	var validationErr *domain.ValidationError
+	if _, err := a.CreateAdmin(ctx, "root", "short"); !errors.As(err, &validationErr) {
+		t.Fatalf("CreateAdmin with a weak password = %v, want a validation error", err)
+	}
+	if _, ok := users.users["root"]; ok {
+		t.Fatal("user registered despite a weak password")
+	}
+
+	user, err = a.CreateAdmin(ctx, "root", "a long enough password")
+	if err != nil {
+		t.Fatalf("CreateAdmin(root): %v", err)
+	}
+	if user.Role != domain.RoleAdmin {
+		t.Errorf("CreateAdmin(root) = %+v, want a new admin", user)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("a long enough password")); err != nil {
+		t.Errorf("stored password does not match: %v", err)
+	}
+}
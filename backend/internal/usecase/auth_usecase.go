@@ -1,35 +1,65 @@
 package usecase
 
 import (
-	_ "crypto/rand"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	_ "fmt"
+	"sync"
 	"time"
 
+	"github.com/xarcher/backend/config"
 	"github.com/xarcher/backend/internal/domain"
 	"github.com/xarcher/backend/internal/infrastructure/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyPasswordHash is compared against when a username does not exist, so
+// logins take as long for unknown usernames as for wrong passwords and do not
+// reveal which usernames are taken. It uses the cost of real password hashes.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 type authUsecase struct {
-	userRepo   domain.UserRepository
-	jwtService jwt.JWTService
-	timeout    time.Duration
+	userRepo         domain.UserRepository
+	refreshTokenRepo domain.RefreshTokenRepository
+	jwtService       jwt.JWTService
+	throttle         *loginThrottle
+	passwordPolicy   config.PasswordPolicyConfig
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	timeout          time.Duration
 }
 
-func NewAuthUsecase(userRepo domain.UserRepository, jwtService jwt.JWTService, timeout time.Duration) domain.AuthUsecase {
+func NewAuthUsecase(userRepo domain.UserRepository, refreshTokenRepo domain.RefreshTokenRepository,
+	loginAttemptRepo domain.LoginAttemptRepository, jwtService jwt.JWTService, authCfg config.AuthConfig,
+	accessTokenTTL time.Duration, refreshTokenTTL time.Duration, timeout time.Duration) domain.AuthUsecase {
 	return &authUsecase{
-		userRepo:   userRepo,
-		jwtService: jwtService,
-		timeout:    timeout,
+		userRepo:         userRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		jwtService:       jwtService,
+		throttle:         &loginThrottle{attemptRepo: loginAttemptRepo, authCfg: authCfg},
+		passwordPolicy:   authCfg.PasswordPolicy,
+		accessTokenTTL:   accessTokenTTL,
+		refreshTokenTTL:  refreshTokenTTL,
+		timeout:          timeout,
 	}
 }
 
-func (a *authUsecase) Register(req *domain.AuthRequest) (*domain.AuthResponse, error) {
-	// Check if user exists
-	existingUser, _ := a.userRepo.GetByUsername(req.Username)
-	if existingUser != nil {
-		return nil, errors.New("user already exists")
+func (a *authUsecase) Register(ctx context.Context, req *domain.AuthRequest) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if err := validateRegistration(req, a.passwordPolicy); err != nil {
+		return nil, err
 	}
 
 	// Hash password
@@ -39,52 +69,229 @@ func (a *authUsecase) Register(req *domain.AuthRequest) (*domain.AuthResponse, e
 	}
 
 	// Create user
+	now := time.Now()
 	user := &domain.User{
 		Username:  req.Username,
 		Password:  string(hashedPassword),
-		CreatedAt: time.Now(),
+		Role:      domain.RoleUser,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
-	if err := a.userRepo.Create(user); err != nil {
+	// The unique constraint on username decides concurrent registrations; the
+	// loser gets domain.ErrUserExists
+	if err := a.userRepo.Create(ctx, user); err != nil {
 		return nil, err
 	}
 
 	// Generate token
-	return a.generateTokenResponse(user)
+	return a.generateTokenResponse(ctx, user, "")
 }
 
-func (a *authUsecase) Login(req *domain.AuthRequest) (*domain.AuthResponse, error) {
+func (a *authUsecase) Login(ctx context.Context, req *domain.AuthRequest, clientIP string) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	// Refuse before spending a bcrypt comparison on a locked username or IP
+	if err := a.throttle.check(ctx, req.Username, clientIP); err != nil {
+		return nil, err
+	}
+
 	// Get user
-	user, err := a.userRepo.GetByUsername(req.Username)
+	user, err := a.userRepo.GetByUsername(ctx, req.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		return nil, a.loginFailed(ctx, req.Username, clientIP)
+	}
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, err
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, a.loginFailed(ctx, req.Username, clientIP)
+	}
+
+	if err := a.throttle.recordSuccess(ctx, user.Username); err != nil {
+		return nil, err
+	}
+
+	if user.DisabledAt != nil {
+		return nil, domain.ErrAccountDisabled
 	}
 
 	// Generate token
-	return a.generateTokenResponse(user)
+	return a.generateTokenResponse(ctx, user, "")
+}
+
+func (a *authUsecase) loginFailed(ctx context.Context, username, clientIP string) error {
+	if err := a.throttle.recordFailure(ctx, username, clientIP); err != nil {
+		return err
+	}
+	return domain.ErrInvalidCredentials
+}
+
+func (a *authUsecase) Refresh(ctx context.Context, req *domain.RefreshRequest) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if req.RefreshToken == "" {
+		return nil, domain.ErrInvalidToken
+	}
+
+	stored, err := a.refreshTokenRepo.GetByHash(ctx, hashRefreshToken(req.RefreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, domain.ErrInvalidToken
+	}
+
+	// Rotate: a token can be exchanged exactly once. Seeing it again means it
+	// leaked, so every token descended from the same login is revoked.
+	fresh, err := a.refreshTokenRepo.MarkUsed(ctx, stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		if err := a.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrTokenReused
+	}
+
+	user, err := a.userRepo.GetByID(ctx, stored.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DisabledAt != nil {
+		return nil, domain.ErrAccountDisabled
+	}
+
+	return a.generateTokenResponse(ctx, user, stored.FamilyID)
+}
+
+// ValidateToken verifies the token and rejects it if the user has since changed
+// their password, logged out everywhere or been disabled. Username and role are
+// refreshed from the database so that changes apply to tokens already issued.
+func (a *authUsecase) ValidateToken(ctx context.Context, token string) (*domain.TokenClaims, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	claims, err := a.jwtService.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.userRepo.GetByID(ctx, claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenVersion != user.TokenVersion {
+		return nil, domain.ErrInvalidToken
+	}
+
+	if user.DisabledAt != nil {
+		return nil, domain.ErrAccountDisabled
+	}
+
+	claims.Username = user.Username
+	claims.Role = user.Role
+
+	return claims, nil
 }
 
-func (a *authUsecase) ValidateToken(token string) (*domain.TokenClaims, error) {
-	return a.jwtService.ValidateToken(token)
+func (a *authUsecase) RevokeToken(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return a.jwtService.RevokeToken(ctx, token)
 }
 
-func (a *authUsecase) RevokeToken(token string) error {
-	return a.jwtService.RevokeToken(token)
+// ChangePassword re-hashes the password and invalidates every outstanding token.
+// The caller gets a fresh token pair so the current session stays signed in.
+func (a *authUsecase) ChangePassword(ctx context.Context, userID int, req *domain.ChangePasswordRequest) (*domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	user, err := a.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	verr := &domain.ValidationError{Message: "validation failed"}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		verr.Add("current_password", "is incorrect")
+		return nil, verr
+	}
+	validatePassword(verr, "new_password", user.Username, req.NewPassword, a.passwordPolicy)
+	if req.NewPassword == req.CurrentPassword {
+		verr.Add("new_password", "must differ from the current password")
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := a.userRepo.UpdatePassword(ctx, userID, string(hashedPassword))
+	if err != nil {
+		return nil, err
+	}
+	user.TokenVersion = version
+
+	if err := a.refreshTokenRepo.RevokeAllForUser(ctx, userID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return a.generateTokenResponse(ctx, user, "")
 }
 
-func (a *authUsecase) generateTokenResponse(user *domain.User) (*domain.AuthResponse, error) {
-	expiresAt := time.Now().Add(24 * time.Hour)
+// LogoutAll invalidates every access and refresh token issued to the user
+func (a *authUsecase) LogoutAll(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if _, err := a.userRepo.IncrementTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	return a.refreshTokenRepo.RevokeAllForUser(ctx, userID, time.Now())
+}
+
+func (a *authUsecase) JWKS() *domain.JSONWebKeySet {
+	return a.jwtService.JWKS()
+}
+
+// generateTokenResponse issues an access token and a refresh token. An empty
+// familyID starts a new refresh token family.
+func (a *authUsecase) generateTokenResponse(ctx context.Context, user *domain.User, familyID string) (*domain.AuthResponse, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.accessTokenTTL)
 
 	claims := &domain.TokenClaims{
-		UserID:    user.ID,
-		Username:  user.Username,
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: expiresAt.Unix(),
+		UserID:       user.ID,
+		Username:     user.Username,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		IssuedAt:     now.Unix(),
+		NotBefore:    now.Unix(),
+		ExpiresAt:    expiresAt.Unix(),
 	}
 
 	token, err := a.jwtService.GenerateToken(claims)
@@ -92,8 +299,47 @@ func (a *authUsecase) generateTokenResponse(user *domain.User) (*domain.AuthResp
 		return nil, err
 	}
 
+	if familyID == "" {
+		familyID, err = randomToken(16)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &domain.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: now.Add(a.refreshTokenTTL),
+		CreatedAt: now,
+	}
+
+	if err := a.refreshTokenRepo.Create(ctx, stored); err != nil {
+		return nil, err
+	}
+
 	return &domain.AuthResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
+		Token:                 token,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: stored.ExpiresAt,
 	}, nil
 }
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type oneUsernameRepo struct {
+	domain.UserRepository
+	user *domain.User
+}
+
+func (r *oneUsernameRepo) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	if username != r.user.Username {
+		return nil, sql.ErrNoRows
+	}
+	return r.user, nil
+}
+
+// noLockoutRepo records failures without ever reaching a threshold
+type noLockoutRepo struct {
+	domain.LoginAttemptRepository
+}
+
+func (noLockoutRepo) Get(ctx context.Context, scope, key string) (*domain.LoginAttempt, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (noLockoutRepo) RecordFailure(ctx context.Context, scope, key string, at time.Time,
+	windowStart time.Time) (*domain.LoginAttempt, error) {
+	return &domain.LoginAttempt{Failures: 1}, nil
+}
+
+func TestDummyPasswordHashMatchesRealCost(t *testing.T) {
+	cost, err := bcrypt.Cost(dummyPasswordHash())
+	if err != nil {
+		t.Fatalf("dummy hash: %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, want %d like registered passwords", cost, bcrypt.DefaultCost)
+	}
+}
+
+// An unknown username must not answer noticeably faster than a wrong password
+func TestLoginUnknownUsernameSpendsBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct password"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &oneUsernameRepo{user: &domain.User{ID: 1, Username: "alice", Password: string(hash)}}
+	a := NewAuthUsecase(users, nil, noLockoutRepo{}, nil,
+		config.AuthConfig{MaxFailuresPerUsername: 100, MaxFailuresPerIP: 100}, time.Hour, time.Hour, time.Minute)
+	dummyPasswordHash()
+
+	login := func(username string) time.Duration {
+		start := time.Now()
+		_, err := a.Login(context.Background(), &domain.AuthRequest{Username: username, Password: "wrong password"}, "127.0.0.1")
+		if !errors.Is(err, domain.ErrInvalidCredentials) {
+			t.Fatalf("Login(%q) = %v, want ErrInvalidCredentials", username, err)
+		}
+		return time.Since(start)
+	}
+
+	var wrongPassword, unknownUser time.Duration
+	for i := 0; i < 3; i++ {
+		wrongPassword += login("alice")
+		unknownUser += login("mallory")
+	}
+	if unknownUser < wrongPassword/2 {
+		t.Errorf("unknown usernames took %v, wrong passwords %v", unknownUser, wrongPassword)
+	}
+}
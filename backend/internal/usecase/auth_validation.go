@@ -0,0 +1,106 @@
+package usecase
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 50 // users.username is VARCHAR(50)
+
+	// bcrypt ignores everything past 72 bytes
+	passwordMaxBytes = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+var commonPasswords = func() map[string]bool {
+	set := make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordsList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			set[strings.ToLower(line)] = true
+		}
+	}
+	return set
+}()
+
+// validateRegistration checks the username charset and length and the password
+// against the configured policy, reporting every violation at once.
+func validateRegistration(req *domain.AuthRequest, policy config.PasswordPolicyConfig) error {
+	verr := &domain.ValidationError{Message: "validation failed"}
+
+	validateUsername(verr, req.Username)
+	validatePassword(verr, "password", req.Username, req.Password, policy)
+
+	return verr.OrNil()
+}
+
+func validateUsername(verr *domain.ValidationError, username string) {
+	switch {
+	case username == "":
+		verr.Add("username", "is required")
+	case len(username) < usernameMinLength || len(username) > usernameMaxLength:
+		verr.Add("username", fmt.Sprintf("must be between %d and %d characters", usernameMinLength, usernameMaxLength))
+	case !usernamePattern.MatchString(username):
+		verr.Add("username", "may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+	}
+}
+
+// validatePassword adds a field error to verr for every policy rule the password breaks
+func validatePassword(verr *domain.ValidationError, field, username, password string, policy config.PasswordPolicyConfig) {
+	if password == "" {
+		verr.Add(field, "is required")
+		return
+	}
+
+	if len([]rune(password)) < policy.MinLength {
+		verr.Add(field, fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+	if len(password) > passwordMaxBytes {
+		verr.Add(field, fmt.Sprintf("must be at most %d bytes", passwordMaxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		verr.Add(field, "must contain an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		verr.Add(field, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		verr.Add(field, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		verr.Add(field, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		verr.Add(field, "is too common")
+	}
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		verr.Add(field, "must not contain the username")
+	}
+}
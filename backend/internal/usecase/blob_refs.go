@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// blobRefs stores upload content once per SHA-256 and tracks how many uploads
+// reference it, so the content is only deleted with its last upload
+type blobRefs struct {
+	blobRepo  domain.BlobRepository
+	blobStore domain.BlobStore
+	uow       domain.UnitOfWork
+}
+
+// errReleaseClaim rolls back the unit of work deleteUnclaimed claims a blob in
+var errReleaseClaim = errors.New("release blob claim")
+
+// errContentDeleted reports that content written by store was deleted with the
+// last upload referencing it before acquire took a new reference
+var errContentDeleted = errors.New("blob content deleted before it was referenced")
+
+// contentKey is the blob key for content with the given hex SHA-256
+func contentKey(hash string) string {
+	return "sha256/" + hash[0:2] + "/" + hash[2:4] + "/" + hash
+}
+
+// withRepo returns blobRefs that record references through blobRepo, such as
+// a repository taking part in a unit of work
+func (b *blobRefs) withRepo(blobRepo domain.BlobRepository) *blobRefs {
+	return &blobRefs{blobRepo: blobRepo, blobStore: b.blobStore, uow: b.uow}
+}
+
+// store writes content under the key for hash unless it is stored already. It
+// runs before the upload is recorded, so no transaction waits on the store;
+// content of an upload that then fails to commit is left for the storage
+// reconciler.
+func (b *blobRefs) store(ctx context.Context, hash string, content io.ReadSeeker) error {
+	key := contentKey(hash)
+	_, err := b.blobStore.Stat(ctx, key)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, domain.ErrBlobNotFound) {
+		return err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = b.blobStore.Put(ctx, key, content)
+	return err
+}
+
+// acquire takes a reference to the blob with the given hash, whose content was
+// written with store. A new record means no upload referenced the content
+// meanwhile, so deleteUnclaimed may have removed it; that is reported as
+// errContentDeleted for the caller to store it again. The new or updated blob
+// record stays locked until the unit of work acquire runs in ends.
+func (b *blobRefs) acquire(ctx context.Context, hash string, size int64) (string, error) {
+	blob := &domain.Blob{
+		Hash:      hash,
+		Key:       contentKey(hash),
+		Size:      size,
+		CreatedAt: time.Now(),
+	}
+	created, err := b.blobRepo.Acquire(ctx, blob)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return blob.Key, nil
+	}
+
+	_, err = b.blobStore.Stat(ctx, blob.Key)
+	if errors.Is(err, domain.ErrBlobNotFound) {
+		return "", errContentDeleted
+	}
+	if err != nil {
+		return "", err
+	}
+	return blob.Key, nil
+}
+
+// unreference drops the upload's reference to its content, removing the blob
+// record with the last one, and reports whether the content is no longer used
+func (b *blobRefs) unreference(ctx context.Context, upload *domain.FileUpload) (bool, error) {
+	if !contentAddressed(upload) {
+		return true, nil
+	}
+
+	blob, err := b.blobRepo.Release(ctx, upload.StoredSHA256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+
+	// Another upload may have taken a new reference in the meantime
+	err = b.blobRepo.DeleteUnreferenced(ctx, upload.StoredSHA256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteContent deletes the upload's content and its variants once dropping its
+// reference was committed and reported the content unused
+func (b *blobRefs) deleteContent(ctx context.Context, upload *domain.FileUpload, variants []*domain.FileUploadVariant) {
+	keys := make([]string, 0, 1+len(variants))
+	keys = append(keys, upload.FilePath)
+	for _, variant := range variants {
+		keys = append(keys, variant.FilePath)
+	}
+
+	if !contentAddressed(upload) {
+		for _, key := range keys {
+			b.deleteBlob(ctx, key)
+		}
+		return
+	}
+	b.deleteUnclaimed(ctx, upload.StoredSHA256, keys)
+}
+
+// deleteUnclaimed deletes keys, the content stored for hash and the variants
+// next to it, unless an upload references hash by now. It claims the blob record
+// in a unit of work of its own and rolls the claim back after deleting, so an
+// upload storing the same content at the same time either waits for the claim
+// and stores the content anew, or holds the record and the content is kept.
+func (b *blobRefs) deleteUnclaimed(ctx context.Context, hash string, keys []string) {
+	err := b.uow.Do(ctx, func(repos *domain.TxRepositories) error {
+		claim := &domain.Blob{Hash: hash, Key: contentKey(hash), CreatedAt: time.Now()}
+		created, err := repos.Blobs.Acquire(ctx, claim)
+		if err != nil {
+			return err
+		}
+		if created {
+			for _, key := range keys {
+				b.deleteBlob(ctx, key)
+			}
+		}
+		return errReleaseClaim
+	})
+	if err != nil && !errors.Is(err, errReleaseClaim) {
+		log.Printf("Failed to claim blob %s for deletion: %v", hash, err)
+	}
+}
+
+// contentAddressed reports whether the upload's content is stored under its
+// hash. Uploads stored before deduplication own a randomly keyed blob outright.
+func contentAddressed(upload *domain.FileUpload) bool {
+	return upload.StoredSHA256 != "" && upload.FilePath == contentKey(upload.StoredSHA256)
+}
+
+func (b *blobRefs) deleteBlob(ctx context.Context, key string) {
+	if err := b.blobStore.Delete(ctx, key); err != nil && !errors.Is(err, domain.ErrBlobNotFound) {
+		log.Printf("Failed to delete blob %s: %v", key, err)
+	}
+}
@@ -0,0 +1,128 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// claimBlobRepo answers Acquire as if the blob record did or did not exist yet
+type claimBlobRepo struct {
+	domain.BlobRepository
+	referenced bool
+	acquired   []string
+}
+
+func (r *claimBlobRepo) Acquire(ctx context.Context, blob *domain.Blob) (bool, error) {
+	r.acquired = append(r.acquired, blob.Hash)
+	return !r.referenced, nil
+}
+
+// rollbackUnitOfWork runs fn and records whether it would have been committed
+type rollbackUnitOfWork struct {
+	repos     *domain.TxRepositories
+	committed bool
+}
+
+func (u *rollbackUnitOfWork) Do(ctx context.Context, fn func(repos *domain.TxRepositories) error) error {
+	err := fn(u.repos)
+	u.committed = err == nil
+	return err
+}
+
+// deletedKeysStore records the keys it was asked to delete
+type deletedKeysStore struct {
+	domain.BlobStore
+	deleted []string
+}
+
+func (s *deletedKeysStore) Delete(ctx context.Context, key string) error {
+	s.deleted = append(s.deleted, key)
+	return nil
+}
+
+func TestBlobRefsDeleteContent(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	upload := &domain.FileUpload{ID: 1, StoredSHA256: hash, FilePath: contentKey(hash)}
+	variants := []*domain.FileUploadVariant{{FilePath: contentKey(hash) + "_thumb.jpg"}}
+
+	tests := []struct {
+		name        string
+		upload      *domain.FileUpload
+		referenced  bool
+		wantClaim   bool
+		wantDeleted int
+	}{
+		{name: "unclaimed", upload: upload, wantClaim: true, wantDeleted: 2},
+		// An upload of the same content committed after the reference was dropped
+		{name: "claimed again", upload: upload, referenced: true, wantClaim: true, wantDeleted: 0},
+		{name: "legacy upload", upload: &domain.FileUpload{ID: 2, StoredSHA256: hash, FilePath: "legacy.jpg"},
+			referenced: true, wantDeleted: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobRepo := &claimBlobRepo{referenced: tt.referenced}
+			uow := &rollbackUnitOfWork{repos: &domain.TxRepositories{Blobs: blobRepo}}
+			store := &deletedKeysStore{}
+			blobs := &blobRefs{blobStore: store, uow: uow}
+
+			blobs.deleteContent(context.Background(), tt.upload, variants)
+
+			if claimed := len(blobRepo.acquired) > 0; claimed != tt.wantClaim {
+				t.Errorf("claimed = %v, want %v", claimed, tt.wantClaim)
+			}
+			if uow.committed {
+				t.Error("the claim on the blob record was committed")
+			}
+			if len(store.deleted) != tt.wantDeleted {
+				t.Errorf("deleted %q, want %d keys", store.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// statStore reports whether the content it is asked about exists
+type statStore struct {
+	domain.BlobStore
+	stored bool
+}
+
+func (s statStore) Stat(ctx context.Context, key string) (*domain.BlobInfo, error) {
+	if !s.stored {
+		return nil, domain.ErrBlobNotFound
+	}
+	return &domain.BlobInfo{Key: key}, nil
+}
+
+func TestBlobRefsAcquire(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name       string
+		referenced bool
+		stored     bool
+		wantErr    error
+	}{
+		{name: "new blob", stored: true},
+		{name: "referenced blob", referenced: true},
+		// The last upload of the content was deleted after store wrote it
+		{name: "content deleted", wantErr: errContentDeleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobs := &blobRefs{blobRepo: &claimBlobRepo{referenced: tt.referenced}, blobStore: statStore{stored: tt.stored}}
+
+			key, err := blobs.acquire(context.Background(), hash, 10)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("acquire error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && key != contentKey(hash) {
+				t.Errorf("acquire key = %q, want %q", key, contentKey(hash))
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// EXIF tags read by parseExif. GPS and serial number tags are never read.
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+	exifTagOffsetOriginal   = 0x9011
+)
+
+// EXIF value types used by the tags above
+const (
+	exifTypeASCII = 2
+	exifTypeShort = 3
+	exifTypeLong  = 4
+)
+
+// exifMaxEntries bounds the entries read from one IFD of untrusted input
+const exifMaxEntries = 512
+
+// imageMetadata is the non-sensitive subset of an image's EXIF data
+type imageMetadata struct {
+	Orientation int
+	CameraMake  string
+	CameraModel string
+	TakenAt     *time.Time
+}
+
+// exifEntry is one IFD entry with its value bytes resolved
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// parseExif reads the metadata from a TIFF-structured EXIF block, as found in
+// a JPEG APP1 segment after the "Exif\0\0" header or a PNG/WebP EXIF chunk.
+// Malformed data yields whatever could be read before the error.
+func parseExif(data []byte) *imageMetadata {
+	meta := &imageMetadata{Orientation: 1}
+	data = bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+	if len(data) < 8 {
+		return meta
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return meta
+	}
+
+	ifd0 := readIFD(data, order, order.Uint32(data[4:8]))
+	if entry, ok := ifd0[exifTagOrientation]; ok && entry.typ == exifTypeShort && len(entry.value) >= 2 {
+		if orientation := int(order.Uint16(entry.value)); orientation >= 1 && orientation <= 8 {
+			meta.Orientation = orientation
+		}
+	}
+	meta.CameraMake = exifString(ifd0[exifTagMake])
+	meta.CameraModel = exifString(ifd0[exifTagModel])
+
+	if entry, ok := ifd0[exifTagExifIFD]; ok && entry.typ == exifTypeLong && len(entry.value) >= 4 {
+		exifIFD := readIFD(data, order, order.Uint32(entry.value))
+		meta.TakenAt = exifTime(exifString(exifIFD[exifTagDateTimeOriginal]),
+			exifString(exifIFD[exifTagOffsetOriginal]))
+	}
+
+	return meta
+}
+
+// readIFD returns the entries of the IFD at offset, keyed by tag
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if uint64(offset)+2 > uint64(len(data)) {
+		return entries
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	if count > exifMaxEntries {
+		return entries
+	}
+
+	for i := 0; i < count; i++ {
+		start := uint64(offset) + 2 + uint64(i)*12
+		if start+12 > uint64(len(data)) {
+			break
+		}
+		raw := data[start : start+12]
+
+		entry := exifEntry{typ: order.Uint16(raw[2:4]), count: order.Uint32(raw[4:8])}
+		size := uint64(entry.count) * exifTypeSize(entry.typ)
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			valueOffset := uint64(order.Uint32(raw[8:12]))
+			if valueOffset+size > uint64(len(data)) {
+				continue
+			}
+			entry.value = data[valueOffset : valueOffset+size]
+		}
+		entries[order.Uint16(raw[0:2])] = entry
+	}
+	return entries
+}
+
+func exifTypeSize(typ uint16) uint64 {
+	switch typ {
+	case exifTypeShort:
+		return 2
+	case exifTypeLong:
+		return 4
+	case exifTypeASCII:
+		return 1
+	default:
+		// Other types are not read, only skipped past
+		return 0
+	}
+}
+
+// exifString decodes a NUL-terminated ASCII value, capped at 100 characters
+func exifString(entry exifEntry) string {
+	if entry.typ != exifTypeASCII {
+		return ""
+	}
+	value := string(entry.value)
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimSpace(value)
+	if len(value) > 100 {
+		value = value[:100]
+	}
+	return value
+}
+
+// exifTime parses an EXIF "2006:01:02 15:04:05" timestamp, using the separate
+// "+07:00" offset when present and UTC otherwise
+func exifTime(value string, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
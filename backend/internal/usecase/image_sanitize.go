@@ -0,0 +1,276 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// sanitizedJPEGQuality is used when a JPEG has to be re-encoded to apply its orientation
+const sanitizedJPEGQuality = 92
+
+var (
+	errMalformedImage = errors.New("malformed image structure")
+	errImageTooLarge  = errors.New("image dimensions too large to process")
+)
+
+// sanitizedImage is the result of sanitizeImage. Width and Height are the
+// dimensions after the orientation was applied.
+type sanitizedImage struct {
+	Content  []byte
+	Width    int
+	Height   int
+	Metadata *imageMetadata
+}
+
+// sanitizeImage removes EXIF, XMP, ICC and text metadata from an image and
+// applies the EXIF orientation to the pixels, returning the cleaned content
+// together with the non-sensitive metadata that was found. JPEG and PNG images
+// are re-encoded only when they need rotating. WebP can only be stripped, as
+// there is no encoder to apply the orientation with, and GIF is returned as is.
+func sanitizeImage(content []byte, format string, width int, height int) (*sanitizedImage, error) {
+	result := &sanitizedImage{Content: content, Width: width, Height: height, Metadata: &imageMetadata{Orientation: 1}}
+
+	var err error
+	switch format {
+	case "jpeg":
+		result.Content, result.Metadata, err = stripJPEG(content)
+	case "png":
+		result.Content, result.Metadata, err = stripPNG(content)
+	case "webp":
+		result.Content, result.Metadata, err = stripWebP(content)
+		result.Metadata.Orientation = 1
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Metadata.Orientation == 1 {
+		return result, nil
+	}
+	if width*height > maxDecodePixels {
+		return nil, errImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(result.Content))
+	if err != nil {
+		return nil, err
+	}
+	oriented := applyOrientation(src, result.Metadata.Orientation)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: sanitizedJPEGQuality})
+	} else {
+		err = png.Encode(&buf, oriented)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Content = buf.Bytes()
+	result.Width, result.Height = oriented.Bounds().Dx(), oriented.Bounds().Dy()
+	return result, nil
+}
+
+// stripJPEG keeps only the JFIF (APP0) and Adobe (APP14) application segments
+// before the image data, dropping EXIF and XMP (APP1), ICC (APP2), IPTC (APP13),
+// the remaining APPn segments and comments
+func stripJPEG(data []byte) ([]byte, *imageMetadata, error) {
+	meta := &imageMetadata{Orientation: 1}
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for {
+		if pos+2 > len(data) || data[pos] != 0xFF {
+			return nil, nil, errMalformedImage
+		}
+		marker := data[pos+1]
+
+		// Fill bytes before a marker
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		// Start of scan or end of image: everything from here on is image data
+		if marker == 0xDA || marker == 0xD9 {
+			out.Write(data[pos:])
+			return out.Bytes(), meta, nil
+		}
+		// Standalone markers carry no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, nil, errMalformedImage
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) || end < pos+4 {
+			return nil, nil, errMalformedImage
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			meta = parseExif(payload)
+		case marker == 0xE0 || marker == 0xEE:
+			out.Write(segment)
+		case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+			// Dropped
+		default:
+			out.Write(segment)
+		}
+		pos = end
+	}
+}
+
+// pngDroppedChunks are the ancillary PNG chunks carrying metadata
+var pngDroppedChunks = map[string]bool{
+	"eXIf": true,
+	"iCCP": true,
+	"iTXt": true,
+	"tEXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, *imageMetadata, error) {
+	meta := &imageMetadata{Orientation: 1}
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLen])
+
+	pos := signatureLen
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) || end < pos {
+			return nil, nil, errMalformedImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+
+		if chunkType == "eXIf" {
+			meta = parseExif(data[pos+8 : pos+8+length])
+		}
+		if !pngDroppedChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), meta, nil
+}
+
+// WebP VP8X feature flags for the chunks stripWebP removes
+const (
+	webpFlagICC  = 0x20
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func stripWebP(data []byte) ([]byte, *imageMetadata, error) {
+	meta := &imageMetadata{Orientation: 1}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2
+		if size < 0 || end > len(data) || end < pos {
+			return nil, nil, errMalformedImage
+		}
+		chunk := data[pos:end]
+
+		switch fourCC {
+		case "EXIF":
+			meta = parseExif(chunk[8 : 8+size])
+		case "ICCP", "XMP ":
+			// Dropped
+		case "VP8X":
+			vp8x := append([]byte(nil), chunk...)
+			if len(vp8x) > 8 {
+				vp8x[8] &^= webpFlagICC | webpFlagEXIF | webpFlagXMP
+			}
+			out.Write(vp8x)
+		default:
+			out.Write(chunk)
+		}
+		pos = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:8], uint32(len(stripped)-8))
+	return stripped, meta, nil
+}
+
+// applyOrientation transforms src as described by an EXIF orientation value
+// (2-8), so that it displays upright without the tag
+func applyOrientation(src image.Image, orientation int) image.Image {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			si := rgba.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], rgba.Pix[si:si+4])
+		}
+	}
+	return dst
+}
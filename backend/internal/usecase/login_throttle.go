@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// loginThrottle tracks failed logins per username and per client IP and locks
+// a key out with exponential backoff once it crosses its threshold.
+type loginThrottle struct {
+	attemptRepo domain.LoginAttemptRepository
+	authCfg     config.AuthConfig
+}
+
+// check returns a *domain.LoginLockedError if any of the keys is currently locked
+func (t *loginThrottle) check(ctx context.Context, username, clientIP string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+
+	for scope, key := range t.keys(username, clientIP) {
+		attempt, err := t.attemptRepo.Get(ctx, scope, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			if remaining := attempt.LockedUntil.Sub(now); remaining > retryAfter {
+				retryAfter = remaining
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return &domain.LoginLockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (t *loginThrottle) recordFailure(ctx context.Context, username, clientIP string) error {
+	now := time.Now()
+
+	for scope, key := range t.keys(username, clientIP) {
+		attempt, err := t.attemptRepo.RecordFailure(ctx, scope, key, now, now.Add(-t.authCfg.FailureWindow))
+		if err != nil {
+			return err
+		}
+
+		threshold := t.authCfg.MaxFailuresPerUsername
+		if scope == domain.LoginScopeIP {
+			threshold = t.authCfg.MaxFailuresPerIP
+		}
+		if attempt.Failures < threshold {
+			continue
+		}
+
+		lockedUntil := now.Add(t.lockoutDuration(attempt.Failures - threshold))
+		if err := t.attemptRepo.Lock(ctx, scope, key, lockedUntil); err != nil {
+			return err
+		}
+		log.Printf("Login locked for %s %q after %d failed attempts, until %s",
+			scope, key, attempt.Failures, lockedUntil.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// recordSuccess clears the username counter. The IP counter is left to expire on
+// its own so that logging into one account does not reset guessing against others.
+func (t *loginThrottle) recordSuccess(ctx context.Context, username string) error {
+	attempt, err := t.attemptRepo.Get(ctx, domain.LoginScopeUsername, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := t.attemptRepo.Reset(ctx, domain.LoginScopeUsername, username); err != nil {
+		return err
+	}
+	if attempt.LockedUntil != nil {
+		log.Printf("Login unlocked for %s %q after successful login", domain.LoginScopeUsername, username)
+	}
+	return nil
+}
+
+// lockoutDuration doubles the base lockout for every failure past the threshold
+func (t *loginThrottle) lockoutDuration(excess int) time.Duration {
+	lockout := t.authCfg.BaseLockout
+	for i := 0; i < excess && lockout < t.authCfg.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > t.authCfg.MaxLockout {
+		lockout = t.authCfg.MaxLockout
+	}
+	return lockout
+}
+
+func (t *loginThrottle) keys(username, clientIP string) map[string]string {
+	keys := map[string]string{domain.LoginScopeUsername: username}
+	if clientIP != "" {
+		keys[domain.LoginScopeIP] = clientIP
+	}
+	return keys
+}
@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// quotaLimits applies the configured default quota, or a user's override, to
+// the usage tracked by the QuotaRepository
+type quotaLimits struct {
+	quotaRepo domain.QuotaRepository
+	quotaCfg  config.QuotaConfig
+}
+
+// withRepo returns quotaLimits that read and update usage through quotaRepo,
+// such as a repository taking part in a unit of work
+func (q *quotaLimits) withRepo(quotaRepo domain.QuotaRepository) *quotaLimits {
+	return &quotaLimits{quotaRepo: quotaRepo, quotaCfg: q.quotaCfg}
+}
+
+// get returns the user's effective limits together with the current usage
+func (q *quotaLimits) get(ctx context.Context, userID int) (*domain.UserQuota, error) {
+	quota := &domain.UserQuota{
+		MaxBytes: q.quotaCfg.MaxBytes,
+		MaxFiles: q.quotaCfg.MaxFiles,
+	}
+
+	override, err := q.quotaRepo.GetOverride(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if override != nil {
+		if override.MaxBytes != nil {
+			quota.MaxBytes = *override.MaxBytes
+		}
+		if override.MaxFiles != nil {
+			quota.MaxFiles = *override.MaxFiles
+		}
+	}
+
+	if quota.UsedBytes, quota.UsedFiles, err = q.quotaRepo.GetUsage(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	quota.RemainingBytes = max(quota.MaxBytes-quota.UsedBytes, 0)
+	quota.RemainingFiles = max(quota.MaxFiles-quota.UsedFiles, 0)
+	return quota, nil
+}
+
+// check fails with a QuotaExceededError if an upload of size bytes would not
+// fit right now. It lets requests fail early; reserve is what enforces the quota.
+func (q *quotaLimits) check(ctx context.Context, userID int, size int64) error {
+	quota, err := q.get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.RemainingFiles < 1 || quota.RemainingBytes < size {
+		return &domain.QuotaExceededError{Quota: quota}
+	}
+	return nil
+}
+
+// reserve atomically counts an upload of size bytes against the user's quota
+func (q *quotaLimits) reserve(ctx context.Context, userID int, size int64) error {
+	quota, err := q.get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = q.quotaRepo.Reserve(ctx, userID, size, quota.MaxBytes, quota.MaxFiles)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Report the usage as it is now, after whatever raced us
+		if current, getErr := q.get(ctx, userID); getErr == nil {
+			quota = current
+		}
+		return &domain.QuotaExceededError{Quota: quota}
+	}
+	return err
+}
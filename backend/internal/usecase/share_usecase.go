@@ -0,0 +1,267 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// shareTokenContext separates share signatures from any other use of the key
+const shareTokenContext = "upload-share-v1:"
+
+type shareUsecase struct {
+	shareRepo     domain.UploadShareRepository
+	uploadRepo    domain.UploadRepository
+	userRepo      domain.UserRepository
+	uploadUsecase domain.UploadUsecase
+	blobStore     domain.BlobStore
+	shareCfg      config.ShareConfig
+	timeout       time.Duration
+}
+
+func NewShareUsecase(shareRepo domain.UploadShareRepository, uploadRepo domain.UploadRepository,
+	userRepo domain.UserRepository, uploadUsecase domain.UploadUsecase, blobStore domain.BlobStore,
+	shareCfg config.ShareConfig, timeout time.Duration) domain.ShareUsecase {
+	return &shareUsecase{
+		shareRepo:     shareRepo,
+		uploadRepo:    uploadRepo,
+		userRepo:      userRepo,
+		uploadUsecase: uploadUsecase,
+		blobStore:     blobStore,
+		shareCfg:      shareCfg,
+		timeout:       timeout,
+	}
+}
+
+func (u *shareUsecase) CreateShare(ctx context.Context, userID int, role string, uploadID int,
+	req *domain.CreateShareRequest) (*domain.UploadShare, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.uploadUsecase.GetUpload(ctx, userID, role, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.ScanStatus == domain.ScanStatusInfected {
+		return nil, domain.ErrUploadInfected
+	}
+
+	now := time.Now()
+	share := &domain.UploadShare{
+		UploadID:     upload.ID,
+		UserID:       upload.UserID,
+		CreatedBy:    &userID,
+		ExpiresAt:    now.Add(u.shareCfg.DefaultTTL),
+		MaxDownloads: req.MaxDownloads,
+		CreatedAt:    now,
+	}
+
+	verr := &domain.ValidationError{Message: "invalid share"}
+	if req.ExpiresAt != nil {
+		share.ExpiresAt = *req.ExpiresAt
+		if !share.ExpiresAt.After(now) {
+			verr.Add("expires_at", "must be in the future")
+		} else if share.ExpiresAt.After(now.Add(u.shareCfg.MaxTTL)) {
+			verr.Add("expires_at", "must be at most "+u.shareCfg.MaxTTL.String()+" from now")
+		}
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads < 1 {
+		verr.Add("max_downloads", "must be at least 1")
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+	// The token carries the expiry in whole seconds
+	share.ExpiresAt = share.ExpiresAt.Truncate(time.Second)
+
+	if err := u.shareRepo.Create(ctx, share); err != nil {
+		return nil, err
+	}
+
+	share.URL = u.shareURL(share)
+	return share, nil
+}
+
+func (u *shareUsecase) ListShares(ctx context.Context, userID int, role string, uploadID int) ([]*domain.UploadShare, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.uploadUsecase.GetUpload(ctx, userID, role, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := u.shareRepo.ListByUploadID(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		share.URL = u.shareURL(share)
+	}
+	return shares, nil
+}
+
+// RevokeShare disables the link for good. Revoking it again is not an error.
+func (u *shareUsecase) RevokeShare(ctx context.Context, userID int, role string, uploadID int, shareID int) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.uploadUsecase.GetUpload(ctx, userID, role, uploadID)
+	if err != nil {
+		return err
+	}
+
+	share, err := u.shareRepo.GetByID(ctx, shareID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if share.UploadID != upload.ID {
+		return domain.ErrNotFound
+	}
+
+	err = u.shareRepo.Revoke(ctx, share.ID, time.Now())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// OpenShare checks the signature and expiry in the token before touching the
+// database. Only uploads that were scanned clean are served, and only while
+// their owner's account is enabled. Links with a download limit reserve the
+// download in the same statement that checks the limit, so no kind of request,
+// ranged or not, gets past it; unlimited links are counted by the caller.
+func (u *shareUsecase) OpenShare(ctx context.Context, token string) (*domain.UploadShare, *domain.FileUpload,
+	io.ReadSeekCloser, error) {
+	shareID, expiresAt, ok := u.parseToken(token)
+	if !ok {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	now := time.Now()
+	if !now.Before(expiresAt) {
+		return nil, nil, nil, domain.ErrShareUnavailable
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	share, err := u.shareRepo.GetByID(dbCtx, shareID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if share.RevokedAt != nil || !now.Before(share.ExpiresAt) ||
+		(share.MaxDownloads != nil && share.DownloadCount >= *share.MaxDownloads) {
+		return nil, nil, nil, domain.ErrShareUnavailable
+	}
+
+	upload, err := u.uploadRepo.GetByID(dbCtx, share.UploadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkScanStatus(upload); err != nil {
+		return nil, nil, nil, err
+	}
+
+	owner, err := u.userRepo.GetByID(dbCtx, upload.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if owner.DisabledAt != nil {
+		return nil, nil, nil, domain.ErrShareUnavailable
+	}
+
+	// The content is read after OpenShare returns, so it is opened with the
+	// request context rather than the timeout
+	content, err := u.blobStore.Get(ctx, upload.FilePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if share.MaxDownloads != nil {
+		counted, err := u.shareRepo.RecordDownload(dbCtx, share.ID, time.Now())
+		if err != nil {
+			content.Close()
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, nil, domain.ErrShareUnavailable
+			}
+			return nil, nil, nil, err
+		}
+		share = counted
+	}
+
+	return share, upload, content, nil
+}
+
+func (u *shareUsecase) RecordDownload(ctx context.Context, shareID int) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	_, err := u.shareRepo.RecordDownload(ctx, shareID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrShareUnavailable
+	}
+	return err
+}
+
+func (u *shareUsecase) shareURL(share *domain.UploadShare) string {
+	return strings.TrimRight(u.shareCfg.BaseURL, "/") + "/s/" + u.signToken(share.ID, share.ExpiresAt)
+}
+
+// signToken encodes the share ID and expiry followed by their HMAC-SHA256, as
+// "<payload>.<signature>" in unpadded base64url
+func (u *shareUsecase) signToken(shareID int, expiresAt time.Time) string {
+	payload := make([]byte, 16)
+	binary.BigEndian.PutUint64(payload[0:8], uint64(shareID))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(expiresAt.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(u.tokenMAC(payload))
+}
+
+func (u *shareUsecase) parseToken(token string) (int, time.Time, bool) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return 0, time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != 16 {
+		return 0, time.Time{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, u.tokenMAC(payload)) {
+		return 0, time.Time{}, false
+	}
+
+	shareID := binary.BigEndian.Uint64(payload[0:8])
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[8:16])), 0)
+	return int(shareID), expiresAt, true
+}
+
+func (u *shareUsecase) tokenMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(u.shareCfg.SecretKey))
+	mac.Write([]byte(shareTokenContext))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
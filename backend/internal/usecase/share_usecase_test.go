@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// oneShareRepo holds one share; concurrent counts downloads taken by other
+// requests after GetByID
+type oneShareRepo struct {
+	domain.UploadShareRepository
+	share      *domain.UploadShare
+	concurrent int
+	recorded   int
+}
+
+func (r *oneShareRepo) GetByID(ctx context.Context, id int) (*domain.UploadShare, error) {
+	share := *r.share
+	return &share, nil
+}
+
+func (r *oneShareRepo) Create(ctx context.Context, share *domain.UploadShare) error {
+	share.ID = 1
+	r.share = share
+	return nil
+}
+
+func (r *oneShareRepo) RecordDownload(ctx context.Context, id int, now time.Time) (*domain.UploadShare, error) {
+	count := r.share.DownloadCount + r.concurrent
+	if r.share.MaxDownloads != nil && count >= *r.share.MaxDownloads {
+		return nil, sql.ErrNoRows
+	}
+	r.recorded++
+	share := *r.share
+	share.DownloadCount = count + 1
+	return &share, nil
+}
+
+type oneUploadRepo struct {
+	domain.UploadRepository
+	upload *domain.FileUpload
+}
+
+func (r *oneUploadRepo) GetByID(ctx context.Context, id int) (*domain.FileUpload, error) {
+	return r.upload, nil
+}
+
+type oneUserRepo struct {
+	domain.UserRepository
+	user *domain.User
+}
+
+func (r *oneUserRepo) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	return r.user, nil
+}
+
+type stringBlobStore struct {
+	domain.BlobStore
+}
+
+func (stringBlobStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return nopReadSeekCloser{strings.NewReader(key)}, nil
+}
+
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error { return nil }
+
+func TestShareUsecaseOpenShare(t *testing.T) {
+	now := time.Now()
+	disabledAt := now.Add(-time.Minute)
+	maxDownloads := 2
+
+	tests := []struct {
+		name         string
+		share        domain.UploadShare
+		concurrent   int
+		owner        domain.User
+		wantErr      error
+		wantRecorded int
+	}{
+		{name: "available"},
+		{name: "limited", share: domain.UploadShare{MaxDownloads: &maxDownloads, DownloadCount: 1}, wantRecorded: 1},
+		{name: "used up in the meantime", share: domain.UploadShare{MaxDownloads: &maxDownloads, DownloadCount: 1},
+			concurrent: 1, wantErr: domain.ErrShareUnavailable},
+		{name: "owner disabled", owner: domain.User{DisabledAt: &disabledAt}, wantErr: domain.ErrShareUnavailable},
+		{name: "revoked", share: domain.UploadShare{RevokedAt: &disabledAt}, wantErr: domain.ErrShareUnavailable},
+		{name: "used up", share: domain.UploadShare{MaxDownloads: &maxDownloads, DownloadCount: 2},
+			wantErr: domain.ErrShareUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			share := tt.share
+			share.ID = 1
+			share.UploadID = 2
+			share.ExpiresAt = now.Add(time.Hour).Truncate(time.Second)
+			owner := tt.owner
+			owner.ID = 3
+
+			shares := &oneShareRepo{share: &share, concurrent: tt.concurrent}
+			u := NewShareUsecase(shares,
+				&oneUploadRepo{upload: &domain.FileUpload{ID: 2, UserID: 3, FilePath: "content", ScanStatus: domain.ScanStatusClean}},
+				&oneUserRepo{user: &owner}, nil, stringBlobStore{},
+				config.ShareConfig{SecretKey: "share-secret"}, time.Second).(*shareUsecase)
+
+			_, upload, content, err := u.OpenShare(context.Background(), u.signToken(share.ID, share.ExpiresAt))
+			if shares.recorded != tt.wantRecorded {
+				t.Errorf("recorded %d downloads, want %d", shares.recorded, tt.wantRecorded)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("OpenShare error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenShare: %v", err)
+			}
+			content.Close()
+			if upload.ID != 2 {
+				t.Errorf("OpenShare upload = %d, want 2", upload.ID)
+			}
+		})
+	}
+}
+
+// oneUploadUsecase hands out one upload to anyone
+type oneUploadUsecase struct {
+	domain.UploadUsecase
+	upload *domain.FileUpload
+}
+
+func (u oneUploadUsecase) GetUpload(ctx context.Context, userID int, role string, uploadID int) (*domain.FileUpload, error) {
+	return u.upload, nil
+}
+
+// A link an admin creates for someone else's upload belongs to the upload's owner
+func TestShareUsecaseCreateShareByAdmin(t *testing.T) {
+	shares := &oneShareRepo{}
+	upload := &domain.FileUpload{ID: 2, UserID: 3, ScanStatus: domain.ScanStatusClean}
+	u := NewShareUsecase(shares, nil, nil, oneUploadUsecase{upload: upload}, nil,
+		config.ShareConfig{SecretKey: "share-secret", DefaultTTL: time.Hour, MaxTTL: time.Hour}, time.Second)
+
+	share, err := u.CreateShare(context.Background(), 7, domain.RoleAdmin, upload.ID, &domain.CreateShareRequest{})
+	if err != nil {
+		t.Fatalf("CreateShare: %v", err)
+	}
+	if share.UserID != 3 || share.CreatedBy == nil || *share.CreatedBy != 7 {
+		t.Errorf("share owned by %d, created by %v, want owned by 3 and created by 7", share.UserID, share.CreatedBy)
+	}
+}
@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// reconcilePageSize is how many records are read per query while reconciling
+const reconcilePageSize = 500
+
+// storageReconciler compares the blob store with the upload, variant and blob
+// records. The store is listed before the records are read, so a file written
+// after the listing is never considered, and anything younger than MinAge is
+// skipped because uploads write their file and record in separate steps.
+type storageReconciler struct {
+	uploadRepo  domain.UploadRepository
+	blobRepo    domain.BlobRepository
+	variantRepo domain.FileUploadVariantRepository
+	blobStore   domain.BlobStore
+	blobs       *blobRefs
+	uow         domain.UnitOfWork
+	gcCfg       config.GCConfig
+}
+
+func NewStorageReconciler(uploadRepo domain.UploadRepository, blobRepo domain.BlobRepository,
+	variantRepo domain.FileUploadVariantRepository, uow domain.UnitOfWork, blobStore domain.BlobStore,
+	gcCfg config.GCConfig) domain.StorageReconciler {
+	return &storageReconciler{
+		uploadRepo:  uploadRepo,
+		blobRepo:    blobRepo,
+		variantRepo: variantRepo,
+		blobStore:   blobStore,
+		blobs:       &blobRefs{blobRepo: blobRepo, blobStore: blobStore, uow: uow},
+		uow:         uow,
+		gcCfg:       gcCfg,
+	}
+}
+
+// Reconcile finds stored files nothing refers to, upload and variant records
+// whose file is missing, and blob records left without references. Unless
+// dryRun is set, orphaned files are deleted and dangling records are removed
+// the same way as deleting them through the API, giving back the quota.
+func (r *storageReconciler) Reconcile(ctx context.Context, dryRun bool) (*domain.StorageReport, error) {
+	report := &domain.StorageReport{
+		DryRun:            dryRun,
+		OrphanedFiles:     []string{},
+		DanglingUploads:   []int{},
+		DanglingVariants:  []int{},
+		UnreferencedBlobs: []string{},
+	}
+	cutoff := time.Now().Add(-r.gcCfg.MinAge)
+
+	stored := map[string]*domain.BlobInfo{}
+	err := r.blobStore.Walk(ctx, func(info *domain.BlobInfo) error {
+		stored[info.Key] = info
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+
+	blobs, err := r.listBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		if blob.RefCount > 0 {
+			referenced[blob.Key] = true
+		} else if blob.CreatedAt.Before(cutoff) {
+			report.UnreferencedBlobs = append(report.UnreferencedBlobs, blob.Hash)
+		}
+	}
+
+	uploads, err := r.listUploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var danglingUploads []*domain.FileUpload
+	danglingUploadIDs := map[int]bool{}
+	for _, upload := range uploads {
+		referenced[upload.FilePath] = true
+		if stored[upload.FilePath] == nil && upload.CreatedAt.Before(cutoff) {
+			danglingUploads = append(danglingUploads, upload)
+			danglingUploadIDs[upload.ID] = true
+		}
+	}
+
+	variants, err := r.listVariants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var danglingVariants []*domain.FileUploadVariant
+	for _, variant := range variants {
+		referenced[variant.FilePath] = true
+		// Variants of dangling uploads are removed together with the upload
+		if stored[variant.FilePath] == nil && variant.CreatedAt.Before(cutoff) && !danglingUploadIDs[variant.UploadID] {
+			danglingVariants = append(danglingVariants, variant)
+		}
+	}
+
+	for _, hash := range report.UnreferencedBlobs {
+		if dryRun {
+			continue
+		}
+		if err := r.blobRepo.DeleteUnreferenced(ctx, hash); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to delete unreferenced blob record %s: %v", hash, err)
+		}
+	}
+
+	for key, info := range stored {
+		if referenced[key] || !info.ModTime.Before(cutoff) {
+			continue
+		}
+		if !dryRun && !r.deleteOrphan(ctx, key, cutoff) {
+			continue
+		}
+		report.OrphanedFiles = append(report.OrphanedFiles, key)
+		report.OrphanedBytes += info.Size
+	}
+
+	for _, upload := range danglingUploads {
+		if !dryRun && !r.deleteDanglingUpload(ctx, upload) {
+			continue
+		}
+		report.DanglingUploads = append(report.DanglingUploads, upload.ID)
+	}
+
+	for _, variant := range danglingVariants {
+		if !dryRun && !r.deleteDanglingVariant(ctx, variant) {
+			continue
+		}
+		report.DanglingVariants = append(report.DanglingVariants, variant.ID)
+	}
+
+	return report, nil
+}
+
+// deleteOrphan checks the file once more before deleting it, as a new upload
+// of the same content may have rewritten it since the store was listed
+func (r *storageReconciler) deleteOrphan(ctx context.Context, key string, cutoff time.Time) bool {
+	info, err := r.blobStore.Stat(ctx, key)
+	if err != nil || !info.ModTime.Before(cutoff) {
+		return false
+	}
+	if err := r.blobStore.Delete(ctx, key); err != nil && !errors.Is(err, domain.ErrBlobNotFound) {
+		log.Printf("Failed to delete orphaned file %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (r *storageReconciler) deleteDanglingUpload(ctx context.Context, upload *domain.FileUpload) bool {
+	if _, err := r.blobStore.Stat(ctx, upload.FilePath); !errors.Is(err, domain.ErrBlobNotFound) {
+		return false
+	}
+
+	var variants []*domain.FileUploadVariant
+	var unused bool
+	err := r.uow.Do(ctx, func(repos *domain.TxRepositories) error {
+		var err error
+		if variants, err = repos.Variants.ListByUploadID(ctx, upload.ID); err != nil {
+			return err
+		}
+		if err := repos.Uploads.Delete(ctx, upload.ID); err != nil {
+			return err
+		}
+		if err := repos.Quotas.Release(ctx, upload.UserID, upload.Size); err != nil {
+			return err
+		}
+		unused, err = r.blobs.withRepo(repos.Blobs).unreference(ctx, upload)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to delete dangling upload %d: %v", upload.ID, err)
+		}
+		return false
+	}
+
+	if unused {
+		r.blobs.deleteContent(ctx, upload, variants)
+	}
+	return true
+}
+
+func (r *storageReconciler) deleteDanglingVariant(ctx context.Context, variant *domain.FileUploadVariant) bool {
+	if _, err := r.blobStore.Stat(ctx, variant.FilePath); !errors.Is(err, domain.ErrBlobNotFound) {
+		return false
+	}
+	if err := r.variantRepo.Delete(ctx, variant.ID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to delete dangling variant %d: %v", variant.ID, err)
+		}
+		return false
+	}
+	return true
+}
+
+func (r *storageReconciler) listBlobs(ctx context.Context) ([]*domain.Blob, error) {
+	var all []*domain.Blob
+	after := ""
+	for {
+		page, err := r.blobRepo.ListAfterHash(ctx, after, reconcilePageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < reconcilePageSize {
+			return all, nil
+		}
+		after = page[len(page)-1].Hash
+	}
+}
+
+func (r *storageReconciler) listUploads(ctx context.Context) ([]*domain.FileUpload, error) {
+	var all []*domain.FileUpload
+	after := 0
+	for {
+		page, err := r.uploadRepo.ListAfterID(ctx, after, reconcilePageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < reconcilePageSize {
+			return all, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+func (r *storageReconciler) listVariants(ctx context.Context) ([]*domain.FileUploadVariant, error) {
+	var all []*domain.FileUploadVariant
+	after := 0
+	for {
+		page, err := r.variantRepo.ListAfterID(ctx, after, reconcilePageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < reconcilePageSize {
+			return all, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,146 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// uploadScanner scans pending uploads with a Scanner and records the verdict.
+// Uploads stay pending while the scanner is unreachable and are retried on
+// every RetryInterval, so nothing becomes downloadable without a clean scan.
+type uploadScanner struct {
+	uploadRepo domain.UploadRepository
+	blobStore  domain.BlobStore
+	scanner    domain.Scanner
+	variants   domain.VariantGenerator
+	scanCfg    config.ScanConfig
+	queue      chan *domain.FileUpload
+	// queued holds the IDs of uploads in the queue or being scanned
+	queued sync.Map
+	// requeueAfter is the ID of the last upload requeuePending queued
+	requeueAfter int
+}
+
+func NewUploadScanner(uploadRepo domain.UploadRepository, blobStore domain.BlobStore, scanner domain.Scanner,
+	variants domain.VariantGenerator, scanCfg config.ScanConfig) domain.UploadScanner {
+	return &uploadScanner{
+		uploadRepo: uploadRepo,
+		blobStore:  blobStore,
+		scanner:    scanner,
+		variants:   variants,
+		scanCfg:    scanCfg,
+		queue:      make(chan *domain.FileUpload, scanCfg.QueueSize),
+	}
+}
+
+func (s *uploadScanner) Enqueue(upload *domain.FileUpload) bool {
+	if _, loaded := s.queued.LoadOrStore(upload.ID, true); loaded {
+		return true
+	}
+
+	select {
+	case s.queue <- upload:
+		return true
+	default:
+		s.queued.Delete(upload.ID)
+		return false
+	}
+}
+
+func (s *uploadScanner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.scanCfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case upload := <-s.queue:
+					if err := s.scan(ctx, upload); err != nil {
+						log.Printf("Failed to scan upload %d: %v", upload.ID, err)
+					}
+					s.queued.Delete(upload.ID)
+				}
+			}
+		}()
+	}
+
+	// Pick up uploads left pending by a restart, a full queue or a failed scan
+	s.requeuePending(ctx)
+	ticker := time.NewTicker(s.scanCfg.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			s.requeuePending(ctx)
+		}
+	}
+}
+
+// requeuePending queues the next page of pending uploads, continuing after the
+// last upload it queued and starting over from the oldest once it reached the end,
+// so uploads that keep failing to scan cannot hold back newer ones
+func (s *uploadScanner) requeuePending(ctx context.Context) {
+	uploads, err := s.uploadRepo.ListByScanStatus(ctx, domain.ScanStatusPending, s.requeueAfter, s.scanCfg.QueueSize)
+	if err != nil {
+		log.Printf("Failed to list uploads pending a scan: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if !s.Enqueue(upload) {
+			return
+		}
+		s.requeueAfter = upload.ID
+	}
+	if len(uploads) < s.scanCfg.QueueSize {
+		s.requeueAfter = 0
+	}
+}
+
+// scan runs the upload's content through the scanner. Clean uploads get their
+// variants generated; infected ones stay quarantined with their content kept.
+func (s *uploadScanner) scan(ctx context.Context, upload *domain.FileUpload) error {
+	content, err := s.blobStore.Get(ctx, upload.FilePath)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	result, err := s.scanner.Scan(ctx, content)
+	if err != nil {
+		return err
+	}
+
+	upload.ScanStatus = domain.ScanStatusClean
+	if result.Infected {
+		upload.ScanStatus = domain.ScanStatusInfected
+		upload.ScanSignature = result.Signature
+		log.Printf("Upload %d of user %d is infected: %s", upload.ID, upload.UserID, result.Signature)
+	}
+
+	err = s.uploadRepo.SetScanResult(ctx, upload.ID, upload.ScanStatus, upload.ScanSignature, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted while it was being scanned
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !result.Infected {
+		s.variants.Enqueue(upload)
+	}
+	return nil
+}
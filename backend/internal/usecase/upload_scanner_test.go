@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// pendingUploadRepo serves ListByScanStatus from a fixed list of pending uploads
+type pendingUploadRepo struct {
+	domain.UploadRepository
+	pending []*domain.FileUpload
+}
+
+func (r *pendingUploadRepo) ListByScanStatus(ctx context.Context, status string, afterID int,
+	limit int) ([]*domain.FileUpload, error) {
+	var page []*domain.FileUpload
+	for _, upload := range r.pending {
+		if upload.ID > afterID && len(page) < limit {
+			page = append(page, upload)
+		}
+	}
+	return page, nil
+}
+
+// Uploads whose scan keeps failing stay pending; requeueing must still reach
+// the ones behind them
+func TestUploadScannerRequeueReachesEveryPendingUpload(t *testing.T) {
+	repo := &pendingUploadRepo{}
+	for id := 1; id <= 5; id++ {
+		repo.pending = append(repo.pending, &domain.FileUpload{ID: id, ScanStatus: domain.ScanStatusPending})
+	}
+	s := NewUploadScanner(repo, nil, nil, nil, config.ScanConfig{QueueSize: 2}).(*uploadScanner)
+
+	var order []int
+	drain := func() {
+		for len(s.queue) > 0 {
+			upload := <-s.queue
+			order = append(order, upload.ID)
+			// The scan failed, the upload stays pending
+			s.queued.Delete(upload.ID)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		s.requeuePending(context.Background())
+		drain()
+	}
+
+	want := []int{1, 2, 3, 4, 5, 1, 2}
+	if len(order) != len(want) {
+		t.Fatalf("queued %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("queued %v, want %v", order, want)
+		}
+	}
+}
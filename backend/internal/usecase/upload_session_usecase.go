@@ -0,0 +1,374 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// uploadSessionUsecase assembles resumable uploads in files below
+// UploadConfig.SessionDir, one per session, and hands the finished file to the
+// regular upload pipeline. The received length and the lock on a session are
+// kept in the database, so a session can continue after a restart from the last
+// offset that was recorded. Requests for a session may reach any instance, so
+// with more than one SessionDir must be shared storage.
+type uploadSessionUsecase struct {
+	sessionRepo   domain.UploadSessionRepository
+	uploadUsecase domain.UploadUsecase
+	quotas        *quotaLimits
+	uploadCfg     config.UploadConfig
+	timeout       time.Duration
+}
+
+// sessionLockLease is how long a request holds a session. It outlasts any
+// request the server lets run, and ends on its own if the instance holding it
+// goes away.
+const sessionLockLease = 5 * time.Minute
+
+func NewUploadSessionUsecase(sessionRepo domain.UploadSessionRepository, uploadUsecase domain.UploadUsecase,
+	quotaRepo domain.QuotaRepository, uploadCfg config.UploadConfig, quotaCfg config.QuotaConfig,
+	timeout time.Duration) domain.UploadSessionUsecase {
+	return &uploadSessionUsecase{
+		sessionRepo:   sessionRepo,
+		uploadUsecase: uploadUsecase,
+		quotas:        &quotaLimits{quotaRepo: quotaRepo, quotaCfg: quotaCfg},
+		uploadCfg:     uploadCfg,
+		timeout:       timeout,
+	}
+}
+
+func (u *uploadSessionUsecase) CreateSession(ctx context.Context, userID int, req *domain.CreateUploadSessionRequest) (*domain.UploadSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	verr := &domain.ValidationError{Message: "invalid upload session"}
+	if req.Filename == "" {
+		verr.Add("filename", "filename is required")
+	}
+	if req.Size <= 0 {
+		verr.Add("size", "size must be greater than 0")
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+	if req.Size > u.uploadCfg.MaxFileSize {
+		return nil, domain.ErrFileTooLarge
+	}
+	if err := u.checkQuota(ctx, userID, req.Size); err != nil {
+		return nil, err
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &domain.UploadSession{
+		ID:          id,
+		UserID:      userID,
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ExpiresAt:   now.Add(u.uploadCfg.SessionTTL),
+	}
+
+	if err := u.sessionRepo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// checkQuota turns away uploads that cannot fit before any bytes are sent. The
+// user's open sessions count as if they were uploads of their declared size,
+// so sessions cannot hold more disk space than the quota allows; the quota
+// itself is enforced when a session is completed.
+func (u *uploadSessionUsecase) checkQuota(ctx context.Context, userID int, size int64) error {
+	quota, err := u.quotas.get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	openFiles, openBytes, err := u.sessionRepo.CountOpen(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if quota.RemainingFiles < openFiles+1 || quota.RemainingBytes < openBytes+size {
+		return &domain.QuotaExceededError{Quota: quota}
+	}
+	return nil
+}
+
+// GetSession returns a live session of the user. Sessions of other users and
+// expired sessions are reported as not found.
+func (u *uploadSessionUsecase) GetSession(ctx context.Context, userID int, id string) (*domain.UploadSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	session, err := u.sessionRepo.GetByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if session.UserID != userID || time.Now().After(session.ExpiresAt) {
+		return nil, domain.ErrNotFound
+	}
+
+	return session, nil
+}
+
+// AppendChunk writes the chunk at offset, which must be the session's current
+// offset. Whatever arrives before the chunk is cut off is kept, so an
+// interrupted PATCH can be resumed from the offset reported afterwards.
+func (u *uploadSessionUsecase) AppendChunk(ctx context.Context, userID int, id string, offset int64,
+	chunk io.Reader) (*domain.UploadSession, error) {
+
+	session, release, err := u.lock(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if offset != session.Offset {
+		return nil, domain.ErrOffsetMismatch
+	}
+
+	if err := os.MkdirAll(u.uploadCfg.SessionDir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(u.sessionPath(id), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Drop anything past the recorded offset left by a write that was never recorded
+	if err := file.Truncate(offset); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	remaining := session.Size - offset
+	written, copyErr := io.Copy(file, &maxSizeReader{r: chunk, remaining: remaining})
+	if errors.Is(copyErr, domain.ErrFileTooLarge) {
+		verr := &domain.ValidationError{Message: "invalid upload chunk"}
+		verr.Add("data", fmt.Sprintf("chunk exceeds the declared upload size of %d bytes", session.Size))
+		return nil, verr
+	}
+	if written == 0 {
+		return session, copyErr
+	}
+
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+
+	// Record the bytes that were written even if the client went away mid-chunk,
+	// so the upload can be resumed from them
+	dbCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+	defer cancel()
+
+	expiresAt := time.Now().Add(u.uploadCfg.SessionTTL)
+	err = u.sessionRepo.UpdateOffset(dbCtx, id, offset, offset+written, expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrOffsetMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.Offset += written
+	session.ExpiresAt = expiresAt
+	return session, copyErr
+}
+
+// Complete uploads the assembled file once every byte has been received. The
+// session is discarded when the upload succeeds or its content is rejected.
+func (u *uploadSessionUsecase) Complete(ctx context.Context, userID int, id string, userAgent string,
+	remoteAddr string) (*domain.FileUpload, error) {
+
+	session, release, err := u.lock(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if session.Offset != session.Size {
+		verr := &domain.ValidationError{Message: "upload incomplete"}
+		verr.Add("size", fmt.Sprintf("received %d of %d bytes", session.Offset, session.Size))
+		return nil, verr
+	}
+
+	file, err := os.Open(u.sessionPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	upload, err := u.uploadUsecase.UploadFile(ctx, userID, session.Filename, session.ContentType,
+		io.LimitReader(file, session.Size), userAgent, remoteAddr)
+
+	var validationErr *domain.ValidationError
+	if err == nil || errors.As(err, &validationErr) || errors.Is(err, domain.ErrFileTooLarge) {
+		u.discard(ctx, session)
+	}
+	return upload, err
+}
+
+func (u *uploadSessionUsecase) Cancel(ctx context.Context, userID int, id string) error {
+	session, release, err := u.lock(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	u.discard(ctx, session)
+	return nil
+}
+
+// PurgeExpiredSessions removes sessions that saw no progress within the TTL,
+// and files left in SessionDir without a session, such as those of deleted
+// accounts whose sessions went with the account
+func (u *uploadSessionUsecase) PurgeExpiredSessions(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	sessions, err := u.sessionRepo.ListExpired(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, session := range sessions {
+		// Skip sessions another request or instance holds right now
+		_, release, err := u.claim(ctx, session.UserID, session.ID)
+		if err != nil {
+			continue
+		}
+		u.discard(ctx, session)
+		release()
+		purged++
+	}
+	return purged + u.purgeOrphanedFiles(ctx), nil
+}
+
+// purgeOrphanedFiles deletes session files whose session no longer exists.
+// Sessions are recorded before their file is first written, and a request only
+// writes to a session it holds, so such a file is never in use.
+func (u *uploadSessionUsecase) purgeOrphanedFiles(ctx context.Context) int {
+	entries, err := os.ReadDir(u.uploadCfg.SessionDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to list upload session files: %v", err)
+		}
+		return 0
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		id := entry.Name()
+		_, err := u.sessionRepo.GetByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := os.Remove(u.sessionPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to delete upload session file %s: %v", id, err)
+			} else {
+				purged++
+			}
+		} else if err != nil {
+			log.Printf("Failed to look up upload session %s: %v", id, err)
+		}
+	}
+	return purged
+}
+
+// lock claims a live session of the user for a single writer and returns it as
+// of the claim. Concurrent requests for the same session, on this instance or
+// another, are rejected rather than queued, as their offsets would be stale.
+func (u *uploadSessionUsecase) lock(ctx context.Context, userID int, id string) (*domain.UploadSession, func(), error) {
+	session, release, err := u.claim(ctx, userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either there is no such session or it is held
+		if _, err := u.GetSession(ctx, userID, id); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, domain.ErrOffsetMismatch
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		release()
+		return nil, nil, domain.ErrNotFound
+	}
+	return session, release, nil
+}
+
+// claim leases the session in the database for sessionLockLease, reporting
+// sql.ErrNoRows if it does not exist or is held. The returned func ends the
+// lease, even once the request was cancelled.
+func (u *uploadSessionUsecase) claim(ctx context.Context, userID int, id string) (*domain.UploadSession, func(), error) {
+	token, err := randomToken(16)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	now := time.Now()
+	session, err := u.sessionRepo.Lock(dbCtx, id, userID, token, now, now.Add(sessionLockLease))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+		defer cancel()
+
+		// A discarded session took its lease with it
+		if err := u.sessionRepo.Unlock(ctx, id, token); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to unlock upload session %s: %v", id, err)
+		}
+	}
+	return session, release, nil
+}
+
+func (u *uploadSessionUsecase) discard(ctx context.Context, session *domain.UploadSession) {
+	// A finished upload must not leave its session behind to be completed
+	// twice, so this runs even when the request was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+	defer cancel()
+
+	if err := u.sessionRepo.Delete(ctx, session.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to delete upload session %s: %v", session.ID, err)
+	}
+	if err := os.Remove(u.sessionPath(session.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to delete upload session file %s: %v", session.ID, err)
+	}
+}
+
+// sessionPath is the file a session is assembled in. Session IDs are generated
+// URL-safe tokens, so they are safe to use as file names.
+func (u *uploadSessionUsecase) sessionPath(id string) string {
+	return filepath.Join(u.uploadCfg.SessionDir, id)
+}
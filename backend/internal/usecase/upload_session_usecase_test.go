@@ -0,0 +1,196 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// memorySessionRepo keeps sessions and their leases in memory
+type memorySessionRepo struct {
+	domain.UploadSessionRepository
+	sessions map[string]*domain.UploadSession
+	leases   map[string]string
+}
+
+func (r *memorySessionRepo) Create(ctx context.Context, session *domain.UploadSession) error {
+	r.sessions[session.ID] = session
+	return nil
+}
+
+func (r *memorySessionRepo) GetByID(ctx context.Context, id string) (*domain.UploadSession, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return session, nil
+}
+
+func (r *memorySessionRepo) Lock(ctx context.Context, id string, userID int, token string, now time.Time,
+	until time.Time) (*domain.UploadSession, error) {
+	session, ok := r.sessions[id]
+	if !ok || session.UserID != userID || r.leases[id] != "" {
+		return nil, sql.ErrNoRows
+	}
+	if r.leases == nil {
+		r.leases = map[string]string{}
+	}
+	r.leases[id] = token
+	locked := *session
+	return &locked, nil
+}
+
+func (r *memorySessionRepo) Unlock(ctx context.Context, id string, token string) error {
+	if r.leases[id] != token {
+		return sql.ErrNoRows
+	}
+	delete(r.leases, id)
+	return nil
+}
+
+func (r *memorySessionRepo) CountOpen(ctx context.Context, userID int, now time.Time) (int, int64, error) {
+	count, size := 0, int64(0)
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			count++
+			size += session.Size
+		}
+	}
+	return count, size, nil
+}
+
+func (r *memorySessionRepo) ListExpired(ctx context.Context, before time.Time) ([]*domain.UploadSession, error) {
+	return nil, nil
+}
+
+// usageQuotaRepo reports a fixed usage and no overrides
+type usageQuotaRepo struct {
+	domain.QuotaRepository
+	usedBytes int64
+	usedFiles int
+}
+
+func (r *usageQuotaRepo) GetOverride(ctx context.Context, userID int) (*domain.QuotaOverride, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (r *usageQuotaRepo) GetUsage(ctx context.Context, userID int) (int64, int, error) {
+	return r.usedBytes, r.usedFiles, nil
+}
+
+// Open sessions count against the quota, so a user cannot hold more than the
+// quota in sessions that are never completed
+func TestUploadSessionCreateCountsOpenSessions(t *testing.T) {
+	sessions := &memorySessionRepo{sessions: map[string]*domain.UploadSession{}}
+	quotas := &usageQuotaRepo{usedBytes: 200, usedFiles: 1}
+	u := NewUploadSessionUsecase(sessions, nil, quotas,
+		config.UploadConfig{MaxFileSize: 1000, SessionTTL: time.Hour},
+		config.QuotaConfig{MaxBytes: 1000, MaxFiles: 3}, time.Second)
+	ctx := context.Background()
+
+	create := func(userID int, size int64) error {
+		_, err := u.CreateSession(ctx, userID, &domain.CreateUploadSessionRequest{Filename: "photo.jpg", Size: size})
+		return err
+	}
+
+	var quotaErr *domain.QuotaExceededError
+	if err := create(1, 500); err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	// 200 used and 500 in the open session leave 300
+	if err := create(1, 400); !errors.As(err, &quotaErr) {
+		t.Fatalf("session over the remaining bytes = %v, want QuotaExceededError", err)
+	}
+	if err := create(1, 300); err != nil {
+		t.Fatalf("session filling the quota: %v", err)
+	}
+	// Bytes remain for other users, whose sessions are not counted here
+	if err := create(2, 700); err != nil {
+		t.Fatalf("session of another user: %v", err)
+	}
+
+	// One file left for user 1 with two open sessions
+	quotas.usedBytes = 0
+	if err := create(1, 1); !errors.As(err, &quotaErr) {
+		t.Fatalf("session over the remaining files = %v, want QuotaExceededError", err)
+	}
+
+	// Expired sessions no longer count
+	for _, session := range sessions.sessions {
+		session.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+	if err := create(1, 800); err != nil {
+		t.Fatalf("session after the others expired: %v", err)
+	}
+}
+
+// Sessions go with a deleted account, their files are swept by the purge
+func TestUploadSessionPurgeRemovesOrphanedFiles(t *testing.T) {
+	dir := t.TempDir()
+	sessions := &memorySessionRepo{sessions: map[string]*domain.UploadSession{
+		"live": {ID: "live", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	u := NewUploadSessionUsecase(sessions, nil, nil, config.UploadConfig{SessionDir: dir},
+		config.QuotaConfig{}, time.Second).(*uploadSessionUsecase)
+
+	for _, name := range []string{"live", "orphaned"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := u.PurgeExpiredSessions(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeExpiredSessions: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d, want 1", purged)
+	}
+
+	for name, wantExists := range map[string]bool{"live": true, "orphaned": false, "subdir": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("%s exists = %v, want %v", name, exists, wantExists)
+		}
+	}
+}
+
+// A session held by a request on one instance is not written to by another
+func TestUploadSessionLockSpansInstances(t *testing.T) {
+	sessions := &memorySessionRepo{sessions: map[string]*domain.UploadSession{
+		"held": {ID: "held", UserID: 1, Size: 10, ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	newInstance := func() *uploadSessionUsecase {
+		return NewUploadSessionUsecase(sessions, nil, nil, config.UploadConfig{SessionDir: t.TempDir()},
+			config.QuotaConfig{}, time.Second).(*uploadSessionUsecase)
+	}
+	first, second := newInstance(), newInstance()
+	ctx := context.Background()
+
+	_, release, err := first.lock(ctx, 1, "held")
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	if _, err := second.AppendChunk(ctx, 1, "held", 0, strings.NewReader("0123")); !errors.Is(err, domain.ErrOffsetMismatch) {
+		t.Fatalf("AppendChunk on a held session = %v, want ErrOffsetMismatch", err)
+	}
+	if _, err := second.AppendChunk(ctx, 2, "held", 0, strings.NewReader("0123")); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("AppendChunk by another user = %v, want ErrNotFound", err)
+	}
+
+	release()
+	if _, _, err := second.lock(ctx, 1, "held"); err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+}
@@ -1,57 +1,479 @@
 package usecase
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/xarcher/backend/config"
-	"strings"
+	"io"
+	"os"
 	"time"
 
 	"github.com/xarcher/backend/internal/domain"
 )
 
 type uploadUsecase struct {
-	uploadRepo domain.UploadRepository
-	uploadCfg  config.UploadConfig
-	timeout    time.Duration
+	uploadRepo  domain.UploadRepository
+	variantRepo domain.FileUploadVariantRepository
+	blobStore   domain.BlobStore
+	blobs       *blobRefs
+	quotas      *quotaLimits
+	uow         domain.UnitOfWork
+	variants    domain.VariantGenerator
+	scanner     domain.UploadScanner
+	uploadCfg   config.UploadConfig
+	timeout     time.Duration
 }
 
-func NewUploadUsecase(uploadRepo domain.UploadRepository, uploadCfg config.UploadConfig, timeout time.Duration) domain.UploadUsecase {
+func NewUploadUsecase(uploadRepo domain.UploadRepository, blobRepo domain.BlobRepository,
+	variantRepo domain.FileUploadVariantRepository, quotaRepo domain.QuotaRepository, uow domain.UnitOfWork,
+	blobStore domain.BlobStore, variants domain.VariantGenerator, scanner domain.UploadScanner,
+	uploadCfg config.UploadConfig, quotaCfg config.QuotaConfig, timeout time.Duration) domain.UploadUsecase {
 	return &uploadUsecase{
-		uploadRepo: uploadRepo,
-		uploadCfg:  uploadCfg,
-		timeout:    timeout,
+		uploadRepo:  uploadRepo,
+		variantRepo: variantRepo,
+		blobStore:   blobStore,
+		blobs:       &blobRefs{blobRepo: blobRepo, blobStore: blobStore, uow: uow},
+		quotas:      &quotaLimits{quotaRepo: quotaRepo, quotaCfg: quotaCfg},
+		uow:         uow,
+		variants:    variants,
+		scanner:     scanner,
+		uploadCfg:   uploadCfg,
+		timeout:     timeout,
 	}
 }
 
-func (u *uploadUsecase) UploadFile(userID int, filename string, contentType string,
-	size int64, filePath string, userAgent string,
-	remoteAddr string) (*domain.FileUpload, error) {
+// UploadFile spools the content to a file below SpoolDir while hashing it and
+// enforcing the size limit, then stores it under its SHA-256 so identical content
+// is kept once. The image format is verified from the content itself before
+// anything is written and, when enabled, metadata is stripped and the orientation
+// applied before storing. The upload is counted against the user's quota once its
+// size is known; the reservation, blob reference and record are committed together.
+// With a scanner configured the upload starts out pending and its variants are
+// only generated once it was scanned clean.
+func (u *uploadUsecase) UploadFile(ctx context.Context, userID int, filename string, contentType string,
+	content io.Reader, userAgent string, remoteAddr string) (*domain.FileUpload, error) {
 
-	// Validate content type
-	if !strings.HasPrefix(contentType, "image/") {
-		return nil, errors.New("file must be an image")
+	// Fail before reading the body if the user has no room left at all
+	if err := u.quotas.check(ctx, userID, 0); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	limited := &maxSizeReader{r: content, remaining: u.uploadCfg.MaxFileSize}
+	buffered := bufio.NewReaderSize(io.TeeReader(limited, hash), sniffLen)
+
+	info, verified, err := inspectImage(buffered, contentType, u.uploadCfg.AllowedFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content cannot be streamed straight into the blob store: its key is
+	// the SHA-256, known only after the last byte, and sanitizing needs the
+	// whole image. The spool lives in a configured directory rather than the
+	// system temp dir, which may be a small tmpfs.
+	if err := os.MkdirAll(u.uploadCfg.SpoolDir, 0755); err != nil {
+		return nil, err
 	}
+	spool, err := os.CreateTemp(u.uploadCfg.SpoolDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	written, err := io.Copy(spool, verified)
+	if err != nil {
+		return nil, err
+	}
+
+	// The checksum is of the file as sent; the stored checksum, which content is
+	// deduplicated by, only differs when sanitizing rewrote the file
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	storedChecksum := checksum
+	var stored io.ReadSeeker = spool
+	width, height := info.Width, info.Height
+	metadata := &imageMetadata{}
+
+	if u.uploadCfg.Sanitize {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		original, err := io.ReadAll(spool)
+		if err != nil {
+			return nil, err
+		}
 
-	// Validate size (8MB limit)
-	const maxSize = 8 * 1024 * 1024
-	if size > maxSize {
-		return nil, errors.New("file size exceeds 8MB limit")
+		sanitized, err := sanitizeImage(original, info.Format, info.Width, info.Height)
+		if err != nil {
+			verr := &domain.ValidationError{Message: "invalid upload"}
+			verr.Add("data", fmt.Sprintf("file could not be processed: %v", err))
+			return nil, verr
+		}
+
+		sum := sha256.Sum256(sanitized.Content)
+		storedChecksum = hex.EncodeToString(sum[:])
+		stored = bytes.NewReader(sanitized.Content)
+		written = int64(len(sanitized.Content))
+		width, height = sanitized.Width, sanitized.Height
+		metadata = sanitized.Metadata
 	}
 
 	upload := &domain.FileUpload{
-		Filename:    filename,
-		ContentType: contentType,
-		Size:        size,
-		FilePath:    filePath,
-		UserAgent:   userAgent,
-		RemoteAddr:  remoteAddr,
-		UserID:      userID,
-		CreatedAt:   time.Now(),
+		Filename:       filename,
+		ContentType:    info.ContentType,
+		Size:           written,
+		Width:          width,
+		Height:         height,
+		CameraMake:     metadata.CameraMake,
+		CameraModel:    metadata.CameraModel,
+		TakenAt:        metadata.TakenAt,
+		ScanStatus:     domain.ScanStatusClean,
+		ChecksumSHA256: checksum,
+		StoredSHA256:   storedChecksum,
+		UserAgent:      userAgent,
+		RemoteAddr:     remoteAddr,
+		UserID:         userID,
+		CreatedAt:      time.Now(),
+	}
+
+	if u.scanner != nil {
+		upload.ScanStatus = domain.ScanStatusPending
+	}
+
+	// The timeout starts once the body has been read, so a slow client only
+	// runs into the server's read timeout
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	// Fail before writing content the quota has no room for
+	if err := u.quotas.check(ctx, userID, written); err != nil {
+		return nil, err
+	}
+
+	// The content is written first, then the quota, the blob reference and the
+	// upload record are committed together. Content deleted with the last
+	// upload of it in between is written again.
+	for attempt := 1; ; attempt++ {
+		if err := u.blobs.store(ctx, storedChecksum, stored); err != nil {
+			return nil, err
+		}
+
+		err = u.uow.Do(ctx, func(repos *domain.TxRepositories) error {
+			if err := u.quotas.withRepo(repos.Quotas).reserve(ctx, userID, written); err != nil {
+				return err
+			}
+
+			key, err := u.blobs.withRepo(repos.Blobs).acquire(ctx, storedChecksum, written)
+			if err != nil {
+				return err
+			}
+
+			upload.FilePath = key
+			return repos.Uploads.Create(ctx, upload)
+		})
+		if !errors.Is(err, errContentDeleted) || attempt == maxStoreAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if err := u.uploadRepo.Create(upload); err != nil {
+	if u.scanner != nil {
+		u.scanner.Enqueue(upload)
+	} else {
+		u.variants.Enqueue(upload)
+	}
+
+	return upload, nil
+}
+
+func (u *uploadUsecase) GetUpload(ctx context.Context, userID int, role string, uploadID int) (*domain.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.uploadRepo.GetByID(ctx, uploadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	if upload.UserID != userID && role != domain.RoleAdmin {
+		return nil, domain.ErrNotFound
+	}
+
 	return upload, nil
 }
+
+// maxStoreAttempts bounds how often UploadFile writes content that keeps being
+// deleted with the last upload of it before the new upload is recorded
+const maxStoreAttempts = 3
+
+const (
+	defaultUploadPageSize = 20
+	maxUploadPageSize     = 100
+)
+
+func (u *uploadUsecase) OpenVariant(ctx context.Context, userID int, role string, uploadID int,
+	name string) (*domain.FileUploadVariant, io.ReadSeekCloser, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.GetUpload(dbCtx, userID, role, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkScanStatus(upload); err != nil {
+		return nil, nil, err
+	}
+
+	variant, err := u.variantRepo.GetByUploadAndName(dbCtx, upload.ID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The content is read after OpenVariant returns, so it is opened with the
+	// request context rather than the timeout
+	content, err := u.blobStore.Get(ctx, variant.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return variant, content, nil
+}
+
+func (u *uploadUsecase) ListUploads(ctx context.Context, userID int, query *domain.UploadListQuery) (*domain.UploadPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	filter, err := parseUploadFilter(userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to find out whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	uploads, err := u.uploadRepo.Find(ctx, *filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.UploadPage{Items: uploads}
+	if len(uploads) > limit {
+		page.Items = uploads[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = encodeUploadCursor(&domain.UploadCursor{
+			ID:         last.ID,
+			CreatedAt:  last.CreatedAt,
+			Size:       last.Size,
+			SortBy:     filter.SortBy,
+			Descending: filter.Descending,
+		})
+	}
+
+	return page, nil
+}
+
+// OpenUpload returns the upload together with its content. The caller closes the
+// content, which stays bound to ctx rather than the usecase timeout while it is read.
+func (u *uploadUsecase) OpenUpload(ctx context.Context, userID int, role string, uploadID int) (*domain.FileUpload, io.ReadSeekCloser, error) {
+	upload, err := u.GetUpload(ctx, userID, role, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkScanStatus(upload); err != nil {
+		return nil, nil, err
+	}
+
+	content, err := u.blobStore.Get(ctx, upload.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return upload, content, nil
+}
+
+// DeleteUpload removes the upload record, its blob reference and its quota
+// usage in one transaction. Content nothing else uses is deleted once that
+// committed, unless an upload of the same content claimed it in the meantime.
+func (u *uploadUsecase) DeleteUpload(ctx context.Context, userID int, role string, uploadID int) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	upload, err := u.GetUpload(ctx, userID, role, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var variants []*domain.FileUploadVariant
+	var unused bool
+	err = u.uow.Do(ctx, func(repos *domain.TxRepositories) error {
+		// Variant records go with the upload, so collect their keys first
+		variants, err = repos.Variants.ListByUploadID(ctx, upload.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := repos.Uploads.Delete(ctx, upload.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return domain.ErrNotFound
+			}
+			return err
+		}
+		if err := repos.Quotas.Release(ctx, upload.UserID, upload.Size); err != nil {
+			return err
+		}
+
+		unused, err = u.blobs.withRepo(repos.Blobs).unreference(ctx, upload)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if unused {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+		defer cancel()
+		u.blobs.deleteContent(cleanupCtx, upload, variants)
+	}
+	return nil
+}
+
+// checkScanStatus only lets the content of uploads that were scanned clean be served
+func checkScanStatus(upload *domain.FileUpload) error {
+	switch upload.ScanStatus {
+	case domain.ScanStatusClean:
+		return nil
+	case domain.ScanStatusInfected:
+		return domain.ErrUploadInfected
+	default:
+		return domain.ErrScanPending
+	}
+}
+
+// maxSizeReader fails with domain.ErrFileTooLarge as soon as more than
+// remaining bytes have been read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return 0, domain.ErrFileTooLarge
+	}
+	return n, err
+}
+
+func parseUploadFilter(userID int, query *domain.UploadListQuery) (*domain.UploadFilter, error) {
+	verr := &domain.ValidationError{Message: "invalid query"}
+	filter := &domain.UploadFilter{
+		UserID:      userID,
+		ContentType: query.ContentType,
+		SortBy:      domain.UploadSortCreatedAt,
+		Descending:  true,
+		Limit:       query.Limit,
+	}
+
+	switch query.SortBy {
+	case "", domain.UploadSortCreatedAt:
+	case domain.UploadSortSize:
+		filter.SortBy = domain.UploadSortSize
+	default:
+		verr.Add("sort", "must be created_at or size")
+	}
+
+	switch query.Order {
+	case "", "desc":
+	case "asc":
+		filter.Descending = false
+	default:
+		verr.Add("order", "must be asc or desc")
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUploadPageSize
+	}
+	if filter.Limit > maxUploadPageSize {
+		filter.Limit = maxUploadPageSize
+	}
+
+	if query.CreatedFrom != "" {
+		from, _, err := parseDate(query.CreatedFrom)
+		if err != nil {
+			verr.Add("created_from", "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		}
+		filter.CreatedFrom = &from
+	}
+	if query.CreatedTo != "" {
+		to, dateOnly, err := parseDate(query.CreatedTo)
+		if err != nil {
+			verr.Add("created_to", "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		}
+		// A bare date includes the whole day
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.CreatedTo = &to
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeUploadCursor(query.Cursor)
+		if err != nil || cursor.SortBy != filter.SortBy || cursor.Descending != filter.Descending {
+			verr.Add("cursor", "is invalid for this sort order")
+		}
+		filter.After = cursor
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseDate accepts an RFC 3339 timestamp or a plain date and reports which one it got
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+func encodeUploadCursor(cursor *domain.UploadCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUploadCursor(value string) (*domain.UploadCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &domain.UploadCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
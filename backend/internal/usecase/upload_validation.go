@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// sniffLen is how much of the content http.DetectContentType looks at
+const sniffLen = 512
+
+// maxDecodePixels bounds the size of images that are fully decoded, for
+// sanitizing or resizing, as decoding allocates four bytes per pixel
+const maxDecodePixels = 50_000_000
+
+// imageFormats maps the sniffed MIME type to the format name image.DecodeConfig
+// reports for it, which is also the name used in UploadConfig.AllowedFormats
+var imageFormats = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// contentTypeAliases are non-canonical types clients commonly declare
+var contentTypeAliases = map[string]string{
+	"image/jpg":   "image/jpeg",
+	"image/pjpeg": "image/jpeg",
+}
+
+// imageInfo is what inspectImage learned from the first bytes of an upload
+type imageInfo struct {
+	ContentType string
+	Format      string
+	Width       int
+	Height      int
+}
+
+// inspectImage sniffs the content type from the first bytes of content, checks
+// it against the allowed formats and the type declared by the client, and decodes
+// the image header to confirm the format and read the dimensions. It returns a
+// reader that yields the complete content again, including the bytes consumed.
+func inspectImage(content *bufio.Reader, declared string, allowedFormats []string) (*imageInfo, io.Reader, error) {
+	verr := &domain.ValidationError{Message: "invalid upload"}
+
+	head, err := content.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+
+	detected := http.DetectContentType(head)
+	format, ok := imageFormats[detected]
+	if !ok || !containsString(allowedFormats, format) {
+		verr.Add("data", fmt.Sprintf("unsupported file type %s", detected))
+		return nil, nil, verr
+	}
+
+	// application/octet-stream is what clients send when they do not know the type
+	if declared != "" {
+		mediaType, _, err := mime.ParseMediaType(declared)
+		if alias, ok := contentTypeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		if err != nil || (mediaType != detected && mediaType != "application/octet-stream") {
+			verr.Add("data", fmt.Sprintf("declared content type %s does not match file contents (%s)", declared, detected))
+			return nil, nil, verr
+		}
+	}
+
+	// Keep everything the decoder reads so it can be replayed into storage
+	var consumed bytes.Buffer
+	cfg, decodedFormat, err := image.DecodeConfig(io.TeeReader(content, &consumed))
+	if errors.Is(err, domain.ErrFileTooLarge) {
+		return nil, nil, err
+	}
+	if err != nil || decodedFormat != format || cfg.Width <= 0 || cfg.Height <= 0 {
+		verr.Add("data", fmt.Sprintf("file is not a valid %s image", format))
+		return nil, nil, verr
+	}
+
+	info := &imageInfo{
+		ContentType: detected,
+		Format:      format,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}
+	return info, io.MultiReader(&consumed, content), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+type userUsecase struct {
+	userRepo domain.UserRepository
+	blobs    *blobRefs
+	quotas   *quotaLimits
+	uow      domain.UnitOfWork
+	timeout  time.Duration
+}
+
+func NewUserUsecase(userRepo domain.UserRepository, blobRepo domain.BlobRepository,
+	quotaRepo domain.QuotaRepository, uow domain.UnitOfWork, blobStore domain.BlobStore,
+	quotaCfg config.QuotaConfig, timeout time.Duration) domain.UserUsecase {
+	return &userUsecase{
+		userRepo: userRepo,
+		blobs:    &blobRefs{blobRepo: blobRepo, blobStore: blobStore, uow: uow},
+		quotas:   &quotaLimits{quotaRepo: quotaRepo, quotaCfg: quotaCfg},
+		uow:      uow,
+		timeout:  timeout,
+	}
+}
+
+func (u *userUsecase) GetProfile(ctx context.Context, userID int) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *userUsecase) UpdateProfile(ctx context.Context, userID int, req *domain.UpdateProfileRequest) (*domain.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	user, err := u.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Username != nil {
+		verr := &domain.ValidationError{Message: "validation failed"}
+		validateUsername(verr, *req.Username)
+		if err := verr.OrNil(); err != nil {
+			return nil, err
+		}
+		user.Username = *req.Username
+	}
+
+	user.UpdatedAt = time.Now()
+	if err := u.userRepo.Update(ctx, user); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetQuota returns the user's storage quota and how much of it is used
+func (u *userUsecase) GetQuota(ctx context.Context, userID int) (*domain.UserQuota, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	return u.quotas.get(ctx, userID)
+}
+
+// DeleteAccount removes the user together with their upload records and refresh
+// tokens (cascaded by the database) and drops the uploads' blob references in one
+// transaction, then deletes the uploaded files nothing else uses from the blob store.
+func (u *userUsecase) DeleteAccount(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	var unused []*domain.FileUpload
+	variants := make(map[int][]*domain.FileUploadVariant)
+	err := u.uow.Do(ctx, func(repos *domain.TxRepositories) error {
+		uploads, err := repos.Uploads.ListByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		// Upload and variant records are removed with the user, so collect the keys first
+		for _, upload := range uploads {
+			if variants[upload.ID], err = repos.Variants.ListByUploadID(ctx, upload.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := repos.Users.Delete(ctx, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return domain.ErrNotFound
+			}
+			return err
+		}
+
+		blobs := u.blobs.withRepo(repos.Blobs)
+		for _, upload := range uploads {
+			released, err := blobs.unreference(ctx, upload)
+			if err != nil {
+				return err
+			}
+			if released {
+				unused = append(unused, upload)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each upload gets its own timeout, so one slow delete does not leave the
+	// rest undone; files that are still left over are found by the reconciler
+	for _, upload := range unused {
+		u.deleteContent(ctx, upload, variants[upload.ID])
+	}
+
+	return nil
+}
+
+func (u *userUsecase) deleteContent(ctx context.Context, upload *domain.FileUpload, variants []*domain.FileUploadVariant) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+	defer cancel()
+	u.blobs.deleteContent(ctx, upload, variants)
+}
@@ -0,0 +1,248 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// variantGenerator renders the configured variants of uploads. Variants are
+// stored next to the original under keys derived from its key, so uploads that
+// share content also share their variants. Uploads that did not fit in the queue
+// are picked up again by the periodic backfill.
+type variantGenerator struct {
+	uploadRepo  domain.UploadRepository
+	variantRepo domain.FileUploadVariantRepository
+	blobStore   domain.BlobStore
+	variantsCfg config.VariantsConfig
+	queue       chan *domain.FileUpload
+	// queued holds the IDs of uploads in the queue or being rendered
+	queued sync.Map
+	// backfillAfter is the ID of the last upload backfill queued
+	backfillAfter int
+}
+
+func NewVariantGenerator(uploadRepo domain.UploadRepository, variantRepo domain.FileUploadVariantRepository,
+	blobStore domain.BlobStore, variantsCfg config.VariantsConfig) domain.VariantGenerator {
+	return &variantGenerator{
+		uploadRepo:  uploadRepo,
+		variantRepo: variantRepo,
+		blobStore:   blobStore,
+		variantsCfg: variantsCfg,
+		queue:       make(chan *domain.FileUpload, variantsCfg.QueueSize),
+	}
+}
+
+func (g *variantGenerator) Enqueue(upload *domain.FileUpload) bool {
+	if len(g.variantsCfg.Definitions) == 0 {
+		return true
+	}
+	if _, loaded := g.queued.LoadOrStore(upload.ID, true); loaded {
+		return true
+	}
+
+	select {
+	case g.queue <- upload:
+		return true
+	default:
+		g.queued.Delete(upload.ID)
+		log.Printf("Variant queue full, deferring variants of upload %d to the backfill", upload.ID)
+		return false
+	}
+}
+
+func (g *variantGenerator) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < g.variantsCfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case upload := <-g.queue:
+					if err := g.generate(ctx, upload); err != nil {
+						log.Printf("Failed to generate variants of upload %d: %v", upload.ID, err)
+					}
+					g.queued.Delete(upload.ID)
+				}
+			}
+		}()
+	}
+
+	if len(g.variantsCfg.Definitions) == 0 {
+		wg.Wait()
+		return
+	}
+
+	// Pick up uploads left without variants by a restart, a full queue or a failure
+	g.backfill(ctx)
+	ticker := time.NewTicker(g.variantsCfg.BackfillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			g.backfill(ctx)
+		}
+	}
+}
+
+// backfill queues the next page of uploads missing a variant, continuing after
+// the last upload it queued and starting over from the oldest once it reached
+// the end, so uploads whose variants keep failing cannot hold back newer ones
+func (g *variantGenerator) backfill(ctx context.Context) {
+	names := make([]string, 0, len(g.variantsCfg.Definitions))
+	for _, variantCfg := range g.variantsCfg.Definitions {
+		names = append(names, variantCfg.Name)
+	}
+
+	uploads, err := g.uploadRepo.ListMissingVariants(ctx, names, g.backfillAfter, g.variantsCfg.QueueSize)
+	if err != nil {
+		log.Printf("Failed to list uploads missing variants: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if !g.Enqueue(upload) {
+			return
+		}
+		g.backfillAfter = upload.ID
+	}
+	if len(uploads) < g.variantsCfg.QueueSize {
+		g.backfillAfter = 0
+	}
+}
+
+// generate renders every configured variant of the upload. Variants whose
+// content already exists, because another upload has the same content, are
+// only recorded.
+func (g *variantGenerator) generate(ctx context.Context, upload *domain.FileUpload) error {
+	if upload.Width*upload.Height > maxDecodePixels {
+		return fmt.Errorf("image of %dx%d is too large to resize", upload.Width, upload.Height)
+	}
+
+	var src image.Image
+	for _, variantCfg := range g.variantsCfg.Definitions {
+		width, height := scaledSize(upload.Width, upload.Height, variantCfg.MaxDimension)
+		variant := &domain.FileUploadVariant{
+			UploadID:    upload.ID,
+			Name:        variantCfg.Name,
+			ContentType: "image/" + variantCfg.Format,
+			Width:       width,
+			Height:      height,
+			FilePath:    variantKey(upload.FilePath, variantCfg),
+			CreatedAt:   time.Now(),
+		}
+
+		info, err := g.blobStore.Stat(ctx, variant.FilePath)
+		if err != nil && !errors.Is(err, domain.ErrBlobNotFound) {
+			return err
+		}
+
+		if err == nil {
+			variant.Size = info.Size
+		} else {
+			if src == nil {
+				if src, err = g.decode(ctx, upload); err != nil {
+					return err
+				}
+			}
+
+			encoded, err := renderVariant(src, width, height, variantCfg)
+			if err != nil {
+				return fmt.Errorf("variant %s: %w", variantCfg.Name, err)
+			}
+			if variant.Size, err = g.blobStore.Put(ctx, variant.FilePath, bytes.NewReader(encoded)); err != nil {
+				return err
+			}
+		}
+
+		if err := g.variantRepo.Upsert(ctx, variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *variantGenerator) decode(ctx context.Context, upload *domain.FileUpload) (image.Image, error) {
+	content, err := g.blobStore.Get(ctx, upload.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	src, _, err := image.Decode(content)
+	return src, err
+}
+
+// renderVariant scales src to width x height and encodes it. JPEG has no alpha
+// channel, so transparent areas are flattened onto white first.
+func renderVariant(src image.Image, width int, height int, variantCfg config.VariantConfig) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	op := draw.Src
+	if variantCfg.Format == "jpeg" {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), op, nil)
+
+	var buf bytes.Buffer
+	var err error
+	switch variantCfg.Format {
+	case "jpeg":
+		quality := variantCfg.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, dst)
+	case "webp":
+		// x/image/webp only decodes; nativewebp encodes lossless WebP
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		err = fmt.Errorf("unsupported variant format %q", variantCfg.Format)
+	}
+	return buf.Bytes(), err
+}
+
+// scaledSize fits width x height within maxDimension, keeping the aspect ratio.
+// Images that already fit keep their size.
+func scaledSize(width int, height int, maxDimension int) (int, int) {
+	if width <= maxDimension && height <= maxDimension {
+		return width, height
+	}
+	if width >= height {
+		return maxDimension, max(1, height*maxDimension/width)
+	}
+	return max(1, width*maxDimension/height), maxDimension
+}
+
+// variantKey places a variant next to the original, e.g. "sha256/ab/cd/<hash>.thumb.jpg"
+func variantKey(originalKey string, variantCfg config.VariantConfig) string {
+	ext := ".jpg"
+	switch variantCfg.Format {
+	case "png":
+		ext = ".png"
+	case "webp":
+		ext = ".webp"
+	}
+	return originalKey + "." + variantCfg.Name + ext
+}
@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+
+	"github.com/xarcher/backend/config"
+	"github.com/xarcher/backend/internal/domain"
+)
+
+// missingVariantsRepo serves ListMissingVariants from a fixed list of uploads
+type missingVariantsRepo struct {
+	domain.UploadRepository
+	missing []*domain.FileUpload
+	names   []string
+}
+
+func (r *missingVariantsRepo) ListMissingVariants(ctx context.Context, names []string, afterID int,
+	limit int) ([]*domain.FileUpload, error) {
+	r.names = names
+	var page []*domain.FileUpload
+	for _, upload := range r.missing {
+		if upload.ID > afterID && len(page) < limit {
+			page = append(page, upload)
+		}
+	}
+	return page, nil
+}
+
+// Uploads dropped from a full queue are queued again by the backfill, page by
+// page, without queueing an upload twice
+func TestVariantGeneratorBackfill(t *testing.T) {
+	repo := &missingVariantsRepo{}
+	for id := 1; id <= 5; id++ {
+		repo.missing = append(repo.missing, &domain.FileUpload{ID: id})
+	}
+	g := NewVariantGenerator(repo, nil, nil, config.VariantsConfig{
+		QueueSize:   2,
+		Definitions: []config.VariantConfig{{Name: "thumb"}, {Name: "medium"}},
+	}).(*variantGenerator)
+
+	if !g.Enqueue(repo.missing[0]) {
+		t.Fatal("Enqueue into an empty queue failed")
+	}
+	if !g.Enqueue(repo.missing[1]) || g.Enqueue(repo.missing[2]) {
+		t.Fatal("the third upload should not fit in a queue of 2")
+	}
+
+	var order []int
+	drain := func() {
+		for len(g.queue) > 0 {
+			upload := <-g.queue
+			order = append(order, upload.ID)
+			// Rendering failed, the upload is still missing its variants
+			g.queued.Delete(upload.ID)
+		}
+	}
+
+	// The queue is still full, the backfill must not duplicate what it holds
+	g.backfill(context.Background())
+	drain()
+	for i := 0; i < 3; i++ {
+		g.backfill(context.Background())
+		drain()
+	}
+
+	if len(repo.names) != 2 || repo.names[0] != "thumb" || repo.names[1] != "medium" {
+		t.Errorf("listed uploads missing %q, want thumb and medium", repo.names)
+	}
+	want := []int{1, 2, 3, 4, 5, 1, 2}
+	if len(order) != len(want) {
+		t.Fatalf("queued %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("queued %v, want %v", order, want)
+		}
+	}
+}
+
+// Every configurable format encodes to something the upload pipeline can
+// decode again, at the requested size
+func TestRenderVariantFormats(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	for _, format := range []string{"jpeg", "png", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			encoded, err := renderVariant(src, 20, 10, config.VariantConfig{Name: "thumb", Format: format})
+			if err != nil {
+				t.Fatalf("renderVariant: %v", err)
+			}
+
+			cfg, decoded, err := image.DecodeConfig(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("DecodeConfig: %v", err)
+			}
+			if decoded != format || cfg.Width != 20 || cfg.Height != 10 {
+				t.Errorf("decoded %s %dx%d, want %s 20x10", decoded, cfg.Width, cfg.Height, format)
+			}
+		})
+	}
+}
@@ -3,7 +3,11 @@ CREATE TABLE users (
                        id SERIAL PRIMARY KEY,
                        username VARCHAR(50) UNIQUE NOT NULL,
                        password VARCHAR(255) NOT NULL,
-                       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+                       role VARCHAR(20) NOT NULL DEFAULT 'user',
+                       token_version INTEGER NOT NULL DEFAULT 0,
+                       disabled_at TIMESTAMP,
+                       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- File uploads table
@@ -13,15 +17,135 @@ CREATE TABLE file_uploads (
                               content_type VARCHAR(100) NOT NULL,
                               size BIGINT NOT NULL,
                               file_path VARCHAR(500) NOT NULL,
+                              checksum_sha256 VARCHAR(64),
+                              stored_sha256 VARCHAR(64),
+                              width INTEGER,
+                              height INTEGER,
+                              camera_make VARCHAR(100),
+                              camera_model VARCHAR(100),
+                              taken_at TIMESTAMP,
+                              scan_status VARCHAR(16) NOT NULL DEFAULT 'clean',
+                              scan_signature VARCHAR(255),
+                              scanned_at TIMESTAMP,
                               user_agent TEXT,
                               remote_addr VARCHAR(45),
-                              user_id INTEGER REFERENCES users(id),
+                              user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- Revoked tokens table (optional, for persistent token revocation)
+CREATE INDEX idx_file_uploads_user_id ON file_uploads (user_id);
+CREATE INDEX idx_file_uploads_user_created ON file_uploads (user_id, created_at, id);
+CREATE INDEX idx_file_uploads_user_size ON file_uploads (user_id, size, id);
+CREATE INDEX idx_file_uploads_checksum ON file_uploads (checksum_sha256);
+CREATE INDEX idx_file_uploads_scan_status_id ON file_uploads (scan_status, id);
+
+-- Generated renditions of uploads (thumbnails and resized variants)
+CREATE TABLE file_upload_variants (
+                                      id SERIAL PRIMARY KEY,
+                                      upload_id INTEGER NOT NULL REFERENCES file_uploads(id) ON DELETE CASCADE,
+                                      name VARCHAR(32) NOT NULL,
+                                      content_type VARCHAR(100) NOT NULL,
+                                      width INTEGER NOT NULL,
+                                      height INTEGER NOT NULL,
+                                      size BIGINT NOT NULL,
+                                      file_path VARCHAR(500) NOT NULL,
+                                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                      UNIQUE (upload_id, name)
+);
+
+-- Blobs table (upload content stored once per SHA-256, shared by reference count)
+CREATE TABLE blobs (
+                       hash VARCHAR(64) PRIMARY KEY,
+                       file_path VARCHAR(500) NOT NULL,
+                       size BIGINT NOT NULL,
+                       ref_count INTEGER NOT NULL DEFAULT 0,
+                       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Revoked tokens table (persistent token revocation, keyed by the token's jti claim)
 CREATE TABLE revoked_tokens (
                                 id SERIAL PRIMARY KEY,
-                                token TEXT NOT NULL,
-                                revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+                                jti VARCHAR(64) NOT NULL,
+                                revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                expires_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_revoked_tokens_jti ON revoked_tokens (jti);
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+-- Refresh tokens table (opaque tokens, stored hashed, rotated on use)
+CREATE TABLE refresh_tokens (
+                                id SERIAL PRIMARY KEY,
+                                user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                token_hash VARCHAR(64) UNIQUE NOT NULL,
+                                family_id VARCHAR(64) NOT NULL,
+                                expires_at TIMESTAMP NOT NULL,
+                                used_at TIMESTAMP,
+                                revoked_at TIMESTAMP,
+                                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- Failed login counters and temporary lockouts, per username and per client IP
+CREATE TABLE login_attempts (
+                                id SERIAL PRIMARY KEY,
+                                scope VARCHAR(16) NOT NULL,
+                                key VARCHAR(255) NOT NULL,
+                                failures INTEGER NOT NULL DEFAULT 0,
+                                last_failure_at TIMESTAMP NOT NULL,
+                                locked_until TIMESTAMP,
+                                UNIQUE (scope, key)
+);
+
+-- Upload sessions table (resumable uploads in progress)
+CREATE TABLE upload_sessions (
+                                 id VARCHAR(64) PRIMARY KEY,
+                                 user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                 filename VARCHAR(255) NOT NULL,
+                                 content_type VARCHAR(100) NOT NULL DEFAULT '',
+                                 size BIGINT NOT NULL,
+                                 upload_offset BIGINT NOT NULL DEFAULT 0,
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 expires_at TIMESTAMP NOT NULL,
+                                 -- The request writing to the session holds it until locked_until
+                                 lock_token VARCHAR(64),
+                                 locked_until TIMESTAMP
+);
+
+CREATE INDEX idx_upload_sessions_expires_at ON upload_sessions (expires_at);
+CREATE INDEX idx_upload_sessions_user_id ON upload_sessions (user_id);
+
+-- Per-user quota overrides (NULL limits use the configured defaults)
+CREATE TABLE user_quotas (
+                             user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                             max_bytes BIGINT,
+                             max_files INTEGER,
+                             updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Running totals of each user's uploads, checked against the quota
+CREATE TABLE user_storage_usage (
+                                    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                                    used_bytes BIGINT NOT NULL DEFAULT 0,
+                                    used_files INTEGER NOT NULL DEFAULT 0,
+                                    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Public share links for uploads (the signed token carries the id and expiry)
+CREATE TABLE upload_shares (
+                               id SERIAL PRIMARY KEY,
+                               upload_id INTEGER NOT NULL REFERENCES file_uploads(id) ON DELETE CASCADE,
+                               user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                               -- Whoever created the link, the owner or an admin
+                               created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+                               expires_at TIMESTAMP NOT NULL,
+                               max_downloads INTEGER,
+                               download_count INTEGER NOT NULL DEFAULT 0,
+                               revoked_at TIMESTAMP,
+                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_upload_shares_upload_id ON upload_shares (upload_id);